@@ -1,281 +1,92 @@
 package main
 
 import (
-	"crypto/tls"
-	"encoding/csv"
-	"fmt"
 	"log"
-	"math/rand"
-	"net/http"
-	"os"
-	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-type Tractor struct {
-	Title              string
-	Price              string
-	ReferencePrice     string
-	ReferenceCurrency  string
-	DisplayedCurrency  string
-	PriceType          string // "ex-VAT" or "inc-VAT"
-	Location           string
-	Dealer             string
-	ImageURL           string
-	DetailURL          string
-	Description        string
-	Details            map[string]string
-}
-
-var userAgents = []string{
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:89.0) Gecko/20100101 Firefox/89.0",
-	// Add more user agents as needed
-}
-
-func getRandomUserAgent() string {
-	return userAgents[rand.Intn(len(userAgents))]
-}
+const agriaffairesOrigin = "https://www.agriaffaires.co.uk"
 
-func createClient() *http.Client {
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
+// agriaffaires advert URLs look like /used/farm-tractor/44698339/fordson-major.html.
+var agriaffairesIDRe = regexp.MustCompile(`/(\d{5,})/[^/]+\.html`)
 
-	client := &http.Client{
-		Timeout:   30 * time.Second,
-		Transport: transport,
-	}
+type agriaffaires struct{}
 
-	return client
+func init() {
+	registerSource(agriaffaires{})
 }
 
-func makeRequest(url string) (*http.Response, error) {
-	client := createClient()
+func (agriaffaires) Name() string { return "agriaffaires" }
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("User-Agent", getRandomUserAgent())
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	return resp, nil
+func (agriaffaires) DefaultURL() string {
+	return agriaffairesOrigin + "/used/farm-tractor/1/16730/fordson-major.html"
 }
 
-func main() {
-	baseURL := "https://www.agriaffaires.co.uk/used/farm-tractor/1/16730/fordson-major.html"
-	var allTractors []Tractor
-
-	rand.Seed(time.Now().UnixNano())
-
-	for page := 1; ; page++ {
-		url := fmt.Sprintf("%s?page=%d", baseURL, page)
-		fmt.Printf("Scraping page %d: %s\n", page, url)
-
-		pageTractors, hasNextPage, err := scrapePage(url)
-		if err != nil {
-			log.Printf("Error scraping page %d: %v", page, err)
-			break
-		}
-		fmt.Printf("Found %d tractors on page %d\n", len(pageTractors), page)
-		
-		allTractors = append(allTractors, pageTractors...)
-		fmt.Printf("Total tractors collected so far: %d\n", len(allTractors))
-
-		if len(pageTractors) == 0 || !hasNextPage {
-			fmt.Printf("No more tractors found or no next page. Stopping.\n")
-			break
-		}
-
-		delay()
-	}
-
-	fmt.Printf("Total tractors found: %d\n", len(allTractors))
-
-	for i := range allTractors {
-		fmt.Printf("Scraping detailed page for tractor %d/%d\n", i+1, len(allTractors))
-		scrapeDetailedPage(&allTractors[i])
-		delay()
-	}
-
-	saveToCsv(allTractors)
-	fmt.Printf("Results saved to results/fordson_major_tractors_%s.csv\n", time.Now().Format("2006-01-02_15-04-05"))
-	fmt.Printf("Total tractors scraped: %d\n", len(allTractors))
+// Politeness keeps to roughly one request every 2-4 seconds, the pace the
+// earlier single-site scripts used.
+func (agriaffaires) Politeness() politeness {
+	return politeness{Workers: 2, RequestsPerSecond: 0.5, Burst: 1, Jitter: 2 * time.Second}
 }
 
-const (
-	baseDelay = 2 * time.Second
-	jitter    = 2 * time.Second
-)
-
-func delay() {
-	time.Sleep(baseDelay + time.Duration(float64(jitter)*rand.Float64()))
+func (agriaffaires) ListingURL(baseURL string, page int) string {
+	return withQuery(baseURL, "page", strconv.Itoa(page))
 }
 
-func scrapePage(url string) ([]Tractor, bool, error) {
-	resp, err := makeRequest(url)
-	if err != nil {
-		return nil, false, fmt.Errorf("error fetching page %s: %v", url, err)
-	}
-	defer resp.Body.Close()
+func (agriaffaires) ParseListing(doc *goquery.Document) ([]Listing, string) {
+	var listings []Listing
+	page := &site("agriaffaires").Listing
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return nil, false, fmt.Errorf("error parsing page %s: %v", url, err)
-	}
+	page.items(doc).Each(func(i int, s *goquery.Selection) {
+		listing := Listing{Source: "agriaffaires", Attributes: make(map[string]string)}
+		v := page.read(s, &listing)
 
-	var tractors []Tractor
+		listing.URL = absoluteURL(agriaffairesOrigin, v["url"])
+		listing.ID = idFromURL(agriaffairesIDRe, listing.URL)
+		listing.Place = parseCountryRegion(listing.Location)
+		readAgriaffairesPrice(v, &listing)
 
-	doc.Find(".listing-block.listing-block--classified").Each(func(i int, s *goquery.Selection) {
-		tractor := Tractor{
-			Details: make(map[string]string),
-		}
-
-		tractor.Title = strings.TrimSpace(s.Find(".listing-block__title").Text())
-		tractor.Location = strings.TrimSpace(s.Find(".listing-block__localisation").Text())
-		tractor.Dealer = strings.TrimSpace(s.Find(".listing-block__category").Text())
-		tractor.ImageURL, _ = s.Find(".listing-block__picture img").Attr("src")
-		tractor.DetailURL, _ = s.Find(".listing-block__link").Attr("href")
-		if !strings.HasPrefix(tractor.DetailURL, "http") {
-			tractor.DetailURL = "https://www.agriaffaires.co.uk" + tractor.DetailURL
-		}
-
-		// Enhanced price information
-		
-		priceElement := s.Find(".listing-block__price")
-		tractor.Price = strings.TrimSpace(priceElement.Find(".js-priceToChange").Text())
-		tractor.ReferencePrice, _ = priceElement.Find(".js-priceToChange").Attr("data-reference_price")
-		tractor.ReferenceCurrency, _ = priceElement.Find(".js-priceToChange").Attr("data-reference_currency")
-		tractor.DisplayedCurrency = strings.TrimSpace(priceElement.Find(".js-currencyToChange").Text())
-		
-		vatText := strings.TrimSpace(priceElement.Find(".h3-like.u-bold").Text())
-		tractor.PriceType = vatText
-
-		s.Find(".listing-block__description span").Each(func(i int, span *goquery.Selection) {
-			text := strings.TrimSpace(span.Text())
-			if strings.Contains(text, "hp") {
-				tractor.Details["Power"] = text
-			} else if strings.Contains(text, "Year") {
-				tractor.Details["Year"] = text
-			}
-		})
-
-		tractors = append(tractors, tractor)
-		fmt.Printf("Found tractor: %s, Price: %s %s (%s)\n", tractor.Title, tractor.Price, tractor.DisplayedCurrency, tractor.PriceType)
+		listings = append(listings, listing)
 	})
 
-	// Check if there's a next page
-	hasNextPage := false
-	doc.Find(".pagination__link").Each(func(i int, s *goquery.Selection) {
-		if strings.Contains(s.Text(), "Next") {
-			hasNextPage = true
-		}
-	})
-	fmt.Printf("Has next page: %v\n", hasNextPage)
-
-	return tractors, hasNextPage, nil
+	return listings, page.nextURL(doc)
 }
 
-
-
-
-func scrapeDetailedPage(tractor *Tractor) {
-	resp, err := makeRequest(tractor.DetailURL)
-	if err != nil {
-		log.Printf("Error fetching detailed page %s: %v", tractor.DetailURL, err)
+// readAgriaffairesPrice takes the price from the reference_price and
+// reference_currency fields, which hold the dealer's own amount and
+// currency; the displayed price is converted client-side to the visitor's
+// currency. vat is the "ex-VAT"/"inc-VAT" note printed next to the price.
+func readAgriaffairesPrice(v map[string]string, listing *Listing) {
+	if v["price"] == "" {
 		return
 	}
-	defer resp.Body.Close()
-
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		log.Printf("Error parsing detailed page %s: %v", tractor.DetailURL, err)
-		return
+	text := v["price"]
+	if v["currency"] != "" {
+		text += " " + v["currency"]
 	}
+	listing.PriceText = strings.TrimSpace(text + " " + v["vat"])
 
-	tractor.Description = strings.TrimSpace(doc.Find("#description_original").Text())
-
-	doc.Find(".table--specs tr").Each(func(i int, s *goquery.Selection) {
-		key := strings.TrimSpace(s.Find("td:first-child").Text())
-		value := strings.TrimSpace(s.Find("td:last-child").Text())
-		if key != "" && value != "" {
-			tractor.Details[key] = value
-		}
-	})
-}
-
-func saveToCsv(tractors []Tractor) {
-	err := os.MkdirAll("./results", os.ModePerm)
-	if err != nil {
-		log.Fatal(err)
+	price, err := parsePrice(listing.PriceText)
+	if amount, ok := parseAmount(v["reference_price"]); ok {
+		price.Amount, err = amount, nil
+		price.Currency = v["reference_currency"]
 	}
-
-	now := time.Now()
-	filename := filepath.Join("./results", fmt.Sprintf("fordson_major_tractors_%s.csv", now.Format("2006-01-02_15-04-05")))
-
-	file, err := os.Create(filename)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Collect all possible detail keys
-	detailKeys := make(map[string]bool)
-	for _, tractor := range tractors {
-		for k := range tractor.Details {
-			detailKeys[k] = true
-		}
-	}
-
-	// Create header
-	header := []string{"Title", "Price", "Location", "Dealer", "Image URL", "Detail URL", "Description"}
-	for k := range detailKeys {
-		header = append(header, k)
-	}
-	if err := writer.Write(header); err != nil {
-		log.Fatal(err)
+		log.Printf("agriaffaires listing %s: %v", listing.ID, err)
 	}
+	listing.Price = price
+}
 
-	// Write data
-	for _, tractor := range tractors {
-		row := []string{
-			tractor.Title,
-			tractor.Price,
-			tractor.Location,
-			tractor.Dealer,
-			tractor.ImageURL,
-			tractor.DetailURL,
-			tractor.Description,
-		}
-		for k := range detailKeys {
-			if v, ok := tractor.Details[k]; ok {
-				row = append(row, v)
-			} else {
-				row = append(row, "")
-			}
-		}
-		if err := writer.Write(row); err != nil {
-			log.Fatal(err)
-		}
+// ParseDetail reads the specification table, price block and dealer contact
+// from an advert page.
+func (agriaffaires) ParseDetail(doc *goquery.Document, listing *Listing) {
+	v := site("agriaffaires").Detail.read(doc.Selection, listing)
+	readAgriaffairesPrice(v, listing)
+	if v["dealer_url"] != "" {
+		listing.Dealer.URL = absoluteURL(agriaffairesOrigin, v["dealer_url"])
 	}
-
-	fmt.Printf("Results saved to %s\n", filename)
-	fmt.Printf("Total tractors scraped: %d\n", len(tractors))
-}
\ No newline at end of file
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCacheDir is where -cache keeps responses unless -cache-dir says
+// otherwise.
+const defaultCacheDir = ".httpcache"
+
+// errNotCached is returned in offline mode for a page that is not in the
+// cache.
+var errNotCached = errors.New("not in cache")
+
+// responseCache keeps fetched pages on disk so that reruns, while working
+// on selectors for instance, do not download them again. Each URL is stored
+// as two files named after its SHA-256: the body as .html, so it can be
+// opened directly, and its metadata as .json.
+type responseCache struct {
+	dir string
+	// ttl is how long an entry is used without asking the site again.
+	// Older entries are revalidated with If-None-Match/If-Modified-Since.
+	ttl time.Duration
+	// offline serves every page from the cache, however old, and never
+	// touches the network.
+	offline bool
+}
+
+// cacheEntry is the metadata stored next to a cached body.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+
+	body []byte
+}
+
+func newResponseCache(dir string, ttl time.Duration, offline bool) (*responseCache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %w", err)
+	}
+	return &responseCache{dir: dir, ttl: ttl, offline: offline}, nil
+}
+
+func (c *responseCache) path(url, ext string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+// load returns the cached entry for url, or nil if there is none.
+func (c *responseCache) load(url string) *cacheEntry {
+	e := c.loadMeta(url)
+	if e == nil {
+		return nil
+	}
+	var err error
+	if e.body, err = os.ReadFile(c.path(url, ".html")); err != nil {
+		return nil
+	}
+	return e
+}
+
+// loadMeta is load without the body, for questions about an entry that
+// do not need the page itself.
+func (c *responseCache) loadMeta(url string) *cacheEntry {
+	meta, err := os.ReadFile(c.path(url, ".json"))
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(meta, &e); err != nil || e.URL != url {
+		return nil
+	}
+	return &e
+}
+
+// usable reports whether e can be served without asking the site.
+func (c *responseCache) usable(e *cacheEntry, now time.Time) bool {
+	return e != nil && (c.offline || now.Sub(e.FetchedAt) < c.ttl)
+}
+
+// store saves a 200 response for url. The body is written before the
+// metadata, and both atomically, so a partly written entry is never loaded.
+func (c *responseCache) store(url string, header http.Header, body []byte, now time.Time) error {
+	e := cacheEntry{
+		URL:          url,
+		FetchedAt:    now.UTC(),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+	if err := writeFileAtomic(c.path(url, ".html"), body); err != nil {
+		return err
+	}
+	return c.touch(&e, now)
+}
+
+// touch records that e was confirmed current at now, after a 304.
+func (c *responseCache) touch(e *cacheEntry, now time.Time) error {
+	e.FetchedAt = now.UTC()
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(e.URL, ".json"), meta)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// revalidatingSite serves one page with the given validator headers and
+// answers a matching conditional request with 304.
+type revalidatingSite struct {
+	etag, lastModified string
+	hits, notModified  atomic.Int32
+}
+
+func (s *revalidatingSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.hits.Add(1)
+	if (s.etag != "" && r.Header.Get("If-None-Match") == s.etag) ||
+		(s.lastModified != "" && r.Header.Get("If-Modified-Since") == s.lastModified) {
+		s.notModified.Add(1)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+	}
+	if s.lastModified != "" {
+		w.Header().Set("Last-Modified", s.lastModified)
+	}
+	w.Write([]byte("<html>page</html>"))
+}
+
+// newCachingFetcher returns a fetcher with a cache in a temporary directory.
+func newCachingFetcher(t *testing.T, dir string, ttl time.Duration, offline bool) *fetcher {
+	t.Helper()
+	cache, err := newResponseCache(dir, ttl, offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newFetcher(fetcherOptions{Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	tests := []struct {
+		name string
+		site *revalidatingSite
+	}{
+		{"etag", &revalidatingSite{etag: `"v1"`}},
+		{"last-modified", &revalidatingSite{lastModified: "Fri, 01 Mar 2024 12:00:00 GMT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.site)
+			defer srv.Close()
+			dir := t.TempDir()
+			ctx := context.Background()
+
+			// Within the TTL the cache answers without a request.
+			f := newCachingFetcher(t, dir, time.Hour, false)
+			for i := 0; i < 2; i++ {
+				if body, err := f.fetch(ctx, srv.URL); err != nil || string(body) != "<html>page</html>" {
+					t.Fatalf("fetch = %q, %v", body, err)
+				}
+			}
+			if got := tt.site.hits.Load(); got != 1 {
+				t.Errorf("%d requests within the TTL, want 1", got)
+			}
+			stored := f.cache.loadMeta(srv.URL)
+
+			// Past it, the page is revalidated and the 304 served from the
+			// cache with its fetch time renewed.
+			time.Sleep(10 * time.Millisecond)
+			f = newCachingFetcher(t, dir, 0, false)
+			if f.cached(srv.URL) {
+				t.Error("expired entry reported as cached")
+			}
+			if body, err := f.fetch(ctx, srv.URL); err != nil || string(body) != "<html>page</html>" {
+				t.Fatalf("revalidated fetch = %q, %v", body, err)
+			}
+			if got := tt.site.notModified.Load(); got != 1 {
+				t.Errorf("%d not-modified responses, want 1", got)
+			}
+			if e := f.cache.loadMeta(srv.URL); e == nil || !e.FetchedAt.After(stored.FetchedAt) {
+				t.Errorf("fetch time after 304 = %v, want later than %v", e, stored.FetchedAt)
+			}
+		})
+	}
+}
+
+func TestCacheOffline(t *testing.T) {
+	site := &revalidatingSite{etag: `"v1"`}
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	online := newCachingFetcher(t, dir, time.Hour, false)
+	before := time.Now()
+	if _, err := online.fetch(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	fetched := online.cache.loadMeta(srv.URL).FetchedAt
+
+	f := newCachingFetcher(t, dir, 0, true)
+	if !f.cached(srv.URL) {
+		t.Error("offline fetcher does not report the page as cached")
+	}
+	if body, err := f.fetch(ctx, srv.URL); err != nil || string(body) != "<html>page</html>" {
+		t.Errorf("offline fetch = %q, %v", body, err)
+	}
+	if got := site.hits.Load(); got != 1 {
+		t.Errorf("%d requests, want the 1 made online", got)
+	}
+	if _, err := f.fetch(ctx, srv.URL+"/other"); !errors.Is(err, errNotCached) {
+		t.Errorf("offline fetch of an uncached page: %v, want %v", err, errNotCached)
+	}
+
+	// Listings parsed offline are as old as the cached page.
+	if got := f.fetchedAt(srv.URL); !got.Equal(fetched) {
+		t.Errorf("offline fetchedAt = %v, want the cache's %v", got, fetched)
+	}
+	if got := online.fetchedAt(srv.URL); got.Before(before) {
+		t.Errorf("online fetchedAt = %v, want now", got)
+	}
+}
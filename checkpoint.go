@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// crawlState is everything a crawl has done so far. It is written to a
+// checkpoint file as the crawl progresses so that an interrupted run can be
+// resumed without refetching pages it already has.
+type crawlState struct {
+	Source    string    `json:"source"`
+	SearchURL string    `json:"search_url"`
+	StartedAt time.Time `json:"started_at"`
+
+	// NextPage is the number of the next results page to fetch, and
+	// NextURL its address, taken from the link on the page before. An
+	// empty NextURL means the source's own URL for page NextPage.
+	NextPage int    `json:"next_page"`
+	NextURL  string `json:"next_url,omitempty"`
+	// Seen holds every advert found on the results pages so far, whether
+	// it matched the filter or not, to notice pages that repeat.
+	Seen map[string]bool `json:"seen,omitempty"`
+	// ListingDone is set once the results pages have all been walked or the
+	// walk was cut short by -pages, -max-items, a repeated page or an error.
+	ListingDone bool `json:"listing_done"`
+	// Complete is set when the walk reached the last results page.
+	Complete bool `json:"complete"`
+
+	Listings []Listing `json:"listings"`
+	// DetailsDone holds the URLs whose detail page has been parsed into
+	// Listings.
+	DetailsDone map[string]bool `json:"details_done"`
+
+	path    string
+	savedAt time.Time
+}
+
+// checkpointInterval is the least time between the checkpoints written as
+// a crawl goes. Each rewrites the whole state, listings and all, so
+// writing one per page would make a large crawl quadratic in I/O.
+const checkpointInterval = 5 * time.Second
+
+func newCrawlState(source, searchURL, path string) *crawlState {
+	return &crawlState{
+		Source:      source,
+		SearchURL:   searchURL,
+		StartedAt:   time.Now(),
+		NextPage:    1,
+		Seen:        make(map[string]bool),
+		DetailsDone: make(map[string]bool),
+		path:        path,
+	}
+}
+
+// loadCrawlState reads a checkpoint file. It returns an error wrapping
+// fs.ErrNotExist when there is none.
+func loadCrawlState(path string) (*crawlState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state crawlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error reading checkpoint %s: %w", path, err)
+	}
+	if state.DetailsDone == nil {
+		state.DetailsDone = make(map[string]bool)
+	}
+	if state.Seen == nil {
+		// Written before Seen was kept: the listings are all we know of.
+		state.Seen = make(map[string]bool)
+		for _, l := range state.Listings {
+			state.Seen[pageKey(l)] = true
+		}
+	}
+	state.path = path
+	return &state, nil
+}
+
+// save writes the state to its checkpoint file. The file is replaced
+// atomically so a crash mid-write leaves the previous checkpoint intact.
+// A state without a path is not saved.
+func (s *crawlState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating checkpoint directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	s.savedAt = time.Now()
+	return nil
+}
+
+// saveSoon saves the state unless it was saved less than
+// checkpointInterval ago.
+func (s *crawlState) saveSoon() error {
+	if time.Since(s.savedAt) < checkpointInterval {
+		return nil
+	}
+	return s.save()
+}
+
+// remove deletes the checkpoint once its results are safely stored.
+func (s *crawlState) remove() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// openCrawlState starts a new crawl or, with resume, picks up the one
+// recorded in the checkpoint at path. A leftover checkpoint is never
+// overwritten silently: without resume it is an error.
+func openCrawlState(source, searchURL, path string, resume bool) (*crawlState, error) {
+	state, err := loadCrawlState(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if resume {
+			fmt.Fprintf(os.Stderr, "No checkpoint at %s; starting a new crawl\n", path)
+		}
+		return newCrawlState(source, searchURL, path), nil
+	case err != nil:
+		return nil, err
+	case !resume:
+		return nil, fmt.Errorf("an interrupted crawl left a checkpoint at %s: pass -resume to continue it or delete the file", path)
+	case state.Source != source || state.SearchURL != searchURL:
+		return nil, fmt.Errorf("checkpoint %s is for %s %s, not %s %s", path, state.Source, state.SearchURL, source, searchURL)
+	}
+	return state, nil
+}
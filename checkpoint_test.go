@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints", "landwirt.json")
+	state := newCrawlState("landwirt", "https://example.com/search", path)
+	state.NextPage = 3
+	state.NextURL = "https://example.com/search?page=3"
+	state.Seen["landwirt/1"] = true
+	state.Listings = []Listing{{Source: "landwirt", ID: "1", Title: "Fendt 724"}}
+	state.DetailsDone["https://example.com/1"] = true
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	got, err := loadCrawlState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NextPage != 3 || got.NextURL != state.NextURL || !got.StartedAt.Equal(state.StartedAt) {
+		t.Errorf("loaded page %d %q started %v, want 3 %q %v", got.NextPage, got.NextURL, got.StartedAt, state.NextURL, state.StartedAt)
+	}
+	if !reflect.DeepEqual(got.Seen, state.Seen) || !reflect.DeepEqual(got.DetailsDone, state.DetailsDone) {
+		t.Errorf("loaded seen %v details %v, want %v %v", got.Seen, got.DetailsDone, state.Seen, state.DetailsDone)
+	}
+	if len(got.Listings) != 1 || got.Listings[0].Title != "Fendt 724" {
+		t.Errorf("loaded listings %+v", got.Listings)
+	}
+	if got.path != path {
+		t.Errorf("loaded path %q, want %q", got.path, path)
+	}
+
+	if err := got.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed: %v", err)
+	}
+	if err := got.remove(); err != nil {
+		t.Errorf("removing twice: %v", err)
+	}
+}
+
+func TestLoadCrawlStateWithoutSeen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.json")
+	old := `{"source":"landwirt","next_page":2,"listings":[{"Source":"landwirt","ID":"7"}]}`
+	if err := os.WriteFile(path, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadCrawlState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{pageKey(Listing{Source: "landwirt", ID: "7"}): true}
+	if !reflect.DeepEqual(state.Seen, want) {
+		t.Errorf("rebuilt seen %v, want %v", state.Seen, want)
+	}
+	if state.DetailsDone == nil {
+		t.Error("DetailsDone is nil")
+	}
+}
+
+func TestOpenCrawlState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "landwirt.json")
+	state, err := openCrawlState("landwirt", "https://example.com/a", path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NextPage != 1 {
+		t.Errorf("new crawl starts at page %d", state.NextPage)
+	}
+	state.NextPage = 5
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source, searchURL string
+		resume            bool
+		wantErr           string
+	}{
+		{"landwirt", "https://example.com/a", false, "pass -resume"},
+		{"landwirt", "https://example.com/b", true, "is for landwirt"},
+		{"agriaffaires", "https://example.com/a", true, "is for landwirt"},
+		{"landwirt", "https://example.com/a", true, ""},
+	}
+	for _, tt := range tests {
+		got, err := openCrawlState(tt.source, tt.searchURL, path, tt.resume)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("openCrawlState(%q, %q, %v) error = %v, want %q", tt.source, tt.searchURL, tt.resume, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("openCrawlState(%q, %q, %v) error = %v", tt.source, tt.searchURL, tt.resume, err)
+		case got.NextPage != 5:
+			t.Errorf("resumed at page %d, want 5", got.NextPage)
+		}
+	}
+}
+
+func TestCrawlStateSaveSoon(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "landwirt.json")
+	state := newCrawlState("landwirt", "https://example.com/a", path)
+	if err := state.saveSoon(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("first saveSoon did not save: %v", err)
+	}
+
+	state.NextPage = 2
+	if err := state.saveSoon(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loadCrawlState(path); got.NextPage != 1 {
+		t.Errorf("saveSoon within the interval wrote page %d", got.NextPage)
+	}
+
+	state.savedAt = time.Now().Add(-checkpointInterval)
+	if err := state.saveSoon(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loadCrawlState(path); got.NextPage != 2 {
+		t.Errorf("saveSoon after the interval wrote page %d, want 2", got.NextPage)
+	}
+}
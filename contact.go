@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+)
+
+// Contact is one way of reaching the dealer of a listing.
+type Contact struct {
+	Type   string `json:"type"`             // contactPhone, contactMobile or contactFax
+	Number string `json:"number,omitempty"` // E.164, e.g. +441452862232; empty if it could not be read
+	Raw    string `json:"raw"`              // the number as the site gave it
+}
+
+const (
+	contactPhone  = "phone"
+	contactMobile = "mobile"
+	contactFax    = "fax"
+)
+
+var (
+	// internationalPrefixRe matches the start of an international number,
+	// written as "(+44)", "+44" or "0044", capturing up to three digits
+	// that begin with the country calling code.
+	internationalPrefixRe = regexp.MustCompile(`^\(?(?:\+|00)(\d{1,3})`)
+	// prefixedBase64Re matches agriaffaires' obfuscated numbers: a key of
+	// 31 lowercase hex digits and then padded standard base64, capturing
+	// the base64.
+	prefixedBase64Re = regexp.MustCompile(`^[0-9a-f]{31}((?:[A-Za-z0-9+/]{4})*(?:[A-Za-z0-9+/]{2}==|[A-Za-z0-9+/]{3}=)?)$`)
+	phoneCharsRe     = regexp.MustCompile(`^[0-9+()./ -]+$`)
+)
+
+// callingCodes lists the assigned country calling codes. No code is the
+// start of another, so the digits after "+" begin with at most one.
+var callingCodes = func() map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.Fields(`
+	1 7 20 27 30 31 32 33 34 36 39 40 41 43 44 45 46 47 48 49
+	51 52 53 54 55 56 57 58 60 61 62 63 64 65 66 81 82 84 86
+	90 91 92 93 94 95 98
+	211 212 213 216 218 220 221 222 223 224 225 226 227 228 229
+	230 231 232 233 234 235 236 237 238 239 240 241 242 243 244
+	245 246 247 248 249 250 251 252 253 254 255 256 257 258 260
+	261 262 263 264 265 266 267 268 269 290 291 297 298 299
+	350 351 352 353 354 355 356 357 358 359 370 371 372 373 374
+	375 376 377 378 379 380 381 382 383 385 386 387 389 420 421
+	423 500 501 502 503 504 505 506 507 508 509 590 591 592 593
+	594 595 596 597 598 599 670 672 673 674 675 676 677 678 679
+	680 681 682 683 685 686 687 688 689 690 691 692 800 808 850
+	852 853 855 856 870 878 880 881 882 883 886 888 960 961 962
+	963 964 965 966 967 968 970 971 972 973 974 975 976 977 979
+	992 993 994 995 996 998`) {
+		codes[code] = true
+	}
+	return codes
+}()
+
+// countryCallingCodes gives the calling code of the countries in
+// countryCodes, for numbers written without one.
+var countryCallingCodes = map[string]string{
+	"AT": "43", "BE": "32", "BG": "359", "CH": "41", "CZ": "420", "DE": "49",
+	"DK": "45", "EE": "372", "ES": "34", "FI": "358", "FR": "33", "GB": "44",
+	"GR": "30", "HR": "385", "HU": "36", "IE": "353", "IT": "39", "LT": "370",
+	"LU": "352", "LV": "371", "NL": "31", "NO": "47", "PL": "48", "PT": "351",
+	"RO": "40", "RS": "381", "SE": "46", "SI": "386", "SK": "421", "UA": "380",
+}
+
+// parseContact reads a phone number and the label shown with it ("Mobile",
+// "Fax", ...). country is the dealer's ISO country code, used for numbers
+// written without a country code. ok is false if raw is empty.
+func parseContact(raw, label, country string) (c Contact, ok bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Contact{}, false
+	}
+	return Contact{Type: contactType(label), Number: normalizePhone(raw, country), Raw: raw}, true
+}
+
+// contactType classifies a number by its label in the languages the sites
+// use. Unlabelled numbers are phones.
+func contactType(label string) string {
+	label = strings.ToLower(label)
+	switch {
+	case strings.Contains(label, "fax") || strings.Contains(label, "télécopie"):
+		return contactFax
+	case strings.Contains(label, "mobil") || strings.Contains(label, "portable") ||
+		strings.Contains(label, "cell") || strings.Contains(label, "handy"):
+		return contactMobile
+	}
+	return contactPhone
+}
+
+// normalizePhone converts a number such as "(+44) 01452 862232" or "+45 12
+// 34 56 78" to E.164 ("+441452862232"). A number without a country code,
+// such as "01452 862232", is taken to be dialled within country, an ISO
+// code. The trunk 0 before the national number is dropped, except for
+// Italy where it is part of the number. It returns "" for numbers whose
+// country is unknown or with an implausible number of digits.
+func normalizePhone(s, country string) string {
+	var code, national string
+	if m := internationalPrefixRe.FindStringSubmatchIndex(s); m != nil {
+		digits := s[m[2]:m[3]]
+		for n := 1; n <= len(digits) && code == ""; n++ {
+			if callingCodes[digits[:n]] {
+				code = digits[:n]
+			}
+		}
+		if code == "" {
+			return ""
+		}
+		national = s[m[2]+len(code):]
+	} else if code = countryCallingCodes[country]; code != "" {
+		national = s
+	} else {
+		return ""
+	}
+	national = strings.ReplaceAll(national, "(0)", "")
+	national = strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, national)
+	if code != "39" {
+		national = strings.TrimLeft(national, "0")
+	}
+
+	number := code + national
+	if len(number) < 8 || len(number) > 15 {
+		return ""
+	}
+	return "+" + number
+}
+
+// decodePrefixedBase64 undoes the obfuscation agriaffaires applies to phone
+// numbers: base64 of the number behind a hexadecimal key, as in
+// "f940ba183c34c3c772d9a60d09f11b7KCs0NCkgMTQ1Mjg2MjIzMg==" for
+// "(+44) 1452862232". Only a value of that shape that decodes to something
+// written like a phone number is decoded; anything else is returned
+// unchanged.
+func decodePrefixedBase64(s string) string {
+	m := prefixedBase64Re.FindStringSubmatch(s)
+	if m == nil || m[1] == "" {
+		return s
+	}
+	decoded, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return s
+	}
+	number := string(decoded)
+	if !phoneCharsRe.MatchString(number) {
+		return s
+	}
+	return number
+}
+
+// formatContacts writes contacts on one line for the CSV export and the
+// terminal.
+func formatContacts(contacts []Contact) string {
+	parts := make([]string, len(contacts))
+	for i, c := range contacts {
+		number := c.Number
+		if number == "" {
+			number = c.Raw
+		}
+		parts[i] = c.Type + ": " + number
+	}
+	return strings.Join(parts, "; ")
+}
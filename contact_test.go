@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestDecodePrefixedBase64(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"f940ba183c34c3c772d9a60d09f11b7KCs0NCkgMTQ1Mjg2MjIzMg==", "(+44) 1452862232"},
+		{"661d26ffcdc277c67bdaeb221f6d3d4KCs0NCkgMTkzNDgzODM4NQ==", "(+44) 1934838385"},
+		{"0123456789abcdef0123456789abcdeKCs0NSkgMTIgMzQgNTYgNzg=", "(+45) 12 34 56 78"},
+		// National numbers are decoded too.
+		{"e6b399963846339270cfe4ebcf10be9MDE4MjMyNTM4MDg=", "01823253808"},
+		{"f940ba183c34c3c772d9a60d09f11b7MDE0NTIgODYyMjMy", "01452 862232"},
+		{"f940ba183c34c3c772d9a60d09f11b7KCs0NCkgMTIz", "(+44) 123"},
+		{"(+44) 01392 496000", "(+44) 01392 496000"},
+		{"not encoded", "not encoded"},
+		// Not the site's shape: no key, a key too short or too long, or
+		// not in lowercase.
+		{"KCs0NSkgMTIgMzQgNTYgNzg=", "KCs0NSkgMTIgMzQgNTYgNzg="},
+		{"f940ba183c34c3c772d9a60d09f11bKCs0NCkgMTQ1Mjg2MjIzMg==", "f940ba183c34c3c772d9a60d09f11bKCs0NCkgMTQ1Mjg2MjIzMg=="},
+		{"f940ba183c34c3c772d9a60d09f11b7aKCs0NCkgMTQ1Mjg2MjIzMg==", "f940ba183c34c3c772d9a60d09f11b7aKCs0NCkgMTQ1Mjg2MjIzMg=="},
+		{"F940BA183C34C3C772D9A60D09F11B7KCs0NCkgMTQ1Mjg2MjIzMg==", "F940BA183C34C3C772D9A60D09F11B7KCs0NCkgMTQ1Mjg2MjIzMg=="},
+		{"f940ba183c34c3c772d9a60d09f11b7KCs0NCkgMTQ1Mjg2MjIzMg", "f940ba183c34c3c772d9a60d09f11b7KCs0NCkgMTQ1Mjg2MjIzMg"},
+		{"f940ba183c34c3c772d9a60d09f11b7", "f940ba183c34c3c772d9a60d09f11b7"},
+		// The right shape, but not a phone number once decoded.
+		{"f940ba183c34c3c772d9a60d09f11b7aGVsbG8gd29ybGQh", "f940ba183c34c3c772d9a60d09f11b7aGVsbG8gd29ybGQh"},
+	}
+	for _, tt := range tests {
+		if got := decodePrefixedBase64(tt.in); got != tt.want {
+			t.Errorf("decodePrefixedBase64(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct{ in, country, want string }{
+		{"(+44) 1452862232", "", "+441452862232"},
+		{"(+44) 01392 496000", "", "+441392496000"},
+		{"+44 (0)1392 496000", "FR", "+441392496000"},
+		{"(+45) 12 34 56 78", "", "+4512345678"},
+		{"0043 7242 123-45", "", "+43724212345"},
+		{"(+39) 0521 123456", "", "+390521123456"},
+		// The calling code is 1, not the first three digits.
+		{"0017157462477", "", "+17157462477"},
+		{"+358 40 1234567", "", "+358401234567"},
+		{"(+999) 1234 5678", "", ""},
+		// National numbers are dialled from the dealer's country.
+		{"01823253808", "GB", "+441823253808"},
+		{"0521 123456", "IT", "+390521123456"},
+		{"01392 496000", "", ""},
+		{"01392 496000", "Atlantis", ""},
+		{"(+44) 123", "", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizePhone(tt.in, tt.country); got != tt.want {
+			t.Errorf("normalizePhone(%q, %q) = %q, want %q", tt.in, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestContactType(t *testing.T) {
+	tests := []struct{ label, want string }{
+		{"Show phone number", contactPhone},
+		{"", contactPhone},
+		{"Mobile", contactMobile},
+		{"Handy", contactMobile},
+		{"Portable", contactMobile},
+		{"Fax", contactFax},
+		{"Télécopie", contactFax},
+	}
+	for _, tt := range tests {
+		if got := contactType(tt.label); got != tt.want {
+			t.Errorf("contactType(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+}
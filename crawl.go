@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+)
+
+// crawlOptions controls how much of a source is fetched and how fast.
+type crawlOptions struct {
+	MaxPages   int // 0 means no limit
+	MaxItems   int // listings to keep, 0 means no limit
+	Details    bool
+	Images     bool // fetch each listing's picture to hash it
+	Politeness politeness
+	// Filter drops the listings that do not match it before their detail
+	// pages are fetched.
+	Filter searchFilter
+}
+
+// crawl walks the results pages of src from state.NextPage, following the
+// next link of each page, and then, if requested, every detail page not yet
+// in state.DetailsDone, and every picture not yet hashed. Progress is
+// checkpointed every checkpointInterval and whenever crawl returns. The
+// walk stops at the last page, at a page with nothing new on it (a site that
+// ignores the page parameter keeps serving the first), or at the MaxPages
+// and MaxItems limits. Results pages that fail to load end the listing
+// walk; detail pages that fail are logged and the listing data is kept. If
+// the site starts blocking requests, or ctx is cancelled, crawl stops and
+// returns an error with state saved for a later resume.
+func crawl(ctx context.Context, src Source, f *fetcher, opts crawlOptions, state *crawlState) (err error) {
+	defer func() {
+		if saveErr := state.save(); err == nil {
+			err = saveErr
+		}
+	}()
+	limiter := newHostLimiter(opts.Politeness)
+
+	for !state.ListingDone {
+		page := state.NextPage
+		if opts.MaxPages > 0 && page > opts.MaxPages {
+			state.ListingDone = true
+			break
+		}
+
+		url := state.NextURL
+		if url == "" {
+			url = src.ListingURL(state.SearchURL, page)
+		}
+		if !f.cached(url) {
+			if err := limiter.wait(ctx, url); err != nil {
+				return err
+			}
+		}
+		log.Printf("Scraping page %d: %s", page, url)
+
+		doc, err := f.fetchDocument(ctx, url)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errBlocked) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Error scraping page %d: %v", page, err)
+			state.ListingDone = true
+			break
+		}
+
+		pageListings, next := src.ParseListing(doc)
+		if len(pageListings) == 0 && page == 1 {
+			// Rather a broken item selector than a search with no results:
+			// taking it as the end would mark every stored listing removed.
+			return fmt.Errorf("%w: %s", errNoListings, url)
+		}
+		// Offline, the listings are as they were when the page was cached.
+		seenAt := f.fetchedAt(url)
+		fresh := 0
+		for _, l := range pageListings {
+			if key := pageKey(l); key != "" {
+				if state.Seen[key] {
+					continue
+				}
+				state.Seen[key] = true
+			}
+			fresh++
+			l.ScrapedAt = seenAt
+			l.SearchURL = state.SearchURL
+			if opts.Filter.matches(&l) {
+				state.Listings = append(state.Listings, l)
+			}
+		}
+		log.Printf("Found %d listings on page %d, %d of them new", len(pageListings), page, fresh)
+		state.NextPage++
+		state.NextURL = next
+
+		switch {
+		case len(pageListings) == 0 || next == "" || next == url:
+			state.ListingDone = true
+			state.Complete = true
+		case fresh == 0:
+			log.Printf("Page %d only repeats earlier results; stopping", page)
+			state.ListingDone = true
+		}
+		if opts.MaxItems > 0 && len(state.Listings) >= opts.MaxItems {
+			if len(state.Listings) > opts.MaxItems {
+				state.Listings = state.Listings[:opts.MaxItems]
+				state.Complete = false
+			}
+			state.ListingDone = true
+		}
+		if err := state.saveSoon(); err != nil {
+			return err
+		}
+	}
+
+	if opts.Details {
+		if err := fetchDetails(ctx, src, f, opts.Politeness.Workers, limiter, state); err != nil {
+			return err
+		}
+	}
+	if opts.Images {
+		return fetchImages(ctx, f, limiter, state)
+	}
+	return nil
+}
+
+// errNoListings is returned when the first results page has no adverts.
+var errNoListings = errors.New("no listings found on the first results page")
+
+// pageKey identifies an advert among the results pages of a crawl.
+func pageKey(l Listing) string {
+	if l.URL != "" {
+		return l.URL
+	}
+	return l.ID
+}
+
+// fetchDetails parses the detail page of every listing not yet done, using
+// up to workers concurrent fetches. It gives up as soon as the site blocks
+// a request.
+func fetchDetails(ctx context.Context, src Source, f *fetcher, workers int, limiter *hostLimiter, state *crawlState) error {
+	var todo []int
+	for i, l := range state.Listings {
+		if l.URL != "" && !state.DetailsDone[l.URL] {
+			todo = append(todo, i)
+		}
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex // guards state, saveErr and blockErr
+		saveErr  error
+		blockErr error
+		wg       sync.WaitGroup
+		jobs     = make(chan int)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				mu.Lock()
+				// Parse into a copy so the checkpoint can be written while
+				// other workers are busy.
+				l := state.Listings[i]
+				l.Attributes = maps.Clone(l.Attributes)
+				mu.Unlock()
+
+				if !f.cached(l.URL) {
+					if err := limiter.wait(ctx, l.URL); err != nil {
+						continue
+					}
+				}
+				log.Printf("Scraping detailed page for listing %d/%d", i+1, len(state.Listings))
+				doc, err := f.fetchDocument(ctx, l.URL)
+				if errors.Is(err, errBlocked) {
+					mu.Lock()
+					if blockErr == nil {
+						blockErr = err
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Error scraping detailed page: %v", err)
+					}
+					continue
+				}
+				src.ParseDetail(doc, &l)
+
+				mu.Lock()
+				state.Listings[i] = l
+				state.DetailsDone[l.URL] = true
+				if err := state.saveSoon(); err != nil && saveErr == nil {
+					saveErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, i := range todo {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	switch {
+	case saveErr != nil:
+		return saveErr
+	case blockErr != nil:
+		return blockErr
+	}
+	return ctx.Err()
+}
+
+// fetchImages hashes the picture of every listing that has one and no hash
+// yet. Pictures that fail to load or decode are logged and skipped.
+func fetchImages(ctx context.Context, f *fetcher, limiter *hostLimiter, state *crawlState) error {
+	for i := range state.Listings {
+		l := &state.Listings[i]
+		if l.ImageURL == "" || l.ImageHash != "" {
+			continue
+		}
+		if !f.cached(l.ImageURL) {
+			if err := limiter.wait(ctx, l.ImageURL); err != nil {
+				return err
+			}
+		}
+		body, err := f.fetch(ctx, l.ImageURL)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errBlocked) {
+			return err
+		}
+		if err == nil {
+			l.ImageHash, err = imageHash(body)
+		}
+		if err != nil {
+			log.Printf("Error hashing picture of listing %s: %v", l.ID, err)
+			continue
+		}
+		if err := state.saveSoon(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
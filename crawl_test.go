@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var fixtureIDRes = map[string]*regexp.Regexp{
+	"landwirt":     landwirtIDRe,
+	"agriaffaires": agriaffairesIDRe,
+}
+
+// fixtureSite serves a source's fixtures as if it were the site: results
+// page n of the default search is listing-n.html and an advert page is
+// detail-<id>.html. Anything else is a 404.
+type fixtureSite struct {
+	src    Source
+	routes map[string]string
+	// status, if set, overrides the response for detail pages.
+	status int
+}
+
+func newFixtureSite(src Source) *fixtureSite {
+	s := &fixtureSite{src: src, routes: make(map[string]string)}
+	for i, fx := range listingFixtures[src.Name()] {
+		u, err := url.Parse(src.ListingURL(src.DefaultURL(), i+1))
+		if err != nil {
+			panic(err)
+		}
+		s.routes[u.RequestURI()] = fx.file
+	}
+	return s
+}
+
+func (s *fixtureSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.routes[r.URL.RequestURI()]
+	if !ok {
+		if id := idFromURL(fixtureIDRes[s.src.Name()], r.URL.Path); id != "" {
+			if s.status != 0 {
+				w.WriteHeader(s.status)
+				return
+			}
+			file = "detail-" + id + ".html"
+		}
+	}
+	if file == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join("testdata", s.src.Name(), file))
+}
+
+// redirectTransport sends every request to the test server whatever its
+// host, so the absolute URLs the sources build reach the fixtures.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newFixtureFetcher(t *testing.T, site http.Handler) *fetcher {
+	t.Helper()
+	srv := httptest.NewServer(site)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+
+	f, err := newFetcher(fetcherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.client.Transport = redirectTransport{target: target}
+	f.minBackoff, f.maxBackoff = time.Millisecond, time.Millisecond
+	return f
+}
+
+// TestCrawl walks every source's fixture site end to end and expects the
+// same records as the parser golden files.
+func TestCrawl(t *testing.T) {
+	for _, name := range sourceNames() {
+		t.Run(name, func(t *testing.T) {
+			src, _ := lookupSource(name)
+			f := newFixtureFetcher(t, newFixtureSite(src))
+			state := newCrawlState(name, src.DefaultURL(), filepath.Join(t.TempDir(), "checkpoint.json"))
+
+			err := crawl(context.Background(), src, f, crawlOptions{Details: true, Politeness: politeness{Workers: 2}}, state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !state.Complete {
+				t.Error("crawl did not reach the last results page")
+			}
+			if want := len(listingFixtures[name]) + 1; state.NextPage != want {
+				t.Errorf("NextPage = %d, want %d", state.NextPage, want)
+			}
+			if len(state.DetailsDone) != len(state.Listings) {
+				t.Errorf("%d detail pages done for %d listings", len(state.DetailsDone), len(state.Listings))
+			}
+			bases := make(map[string]Listing)
+			for _, l := range fixtureListings(t, name) {
+				bases[l.ID] = l
+			}
+			for _, l := range state.Listings {
+				if l.ScrapedAt.IsZero() || l.SearchURL != src.DefaultURL() {
+					t.Errorf("%s: ScrapedAt %v, SearchURL %q not set by the crawl", l.ID, l.ScrapedAt, l.SearchURL)
+				}
+				base := bases[l.ID]
+				checkGolden(t, goldenPath(name, "detail-"+l.ID+".html"), parsedFields(t, l, &base))
+			}
+		})
+	}
+}
+
+func TestCrawlBlocked(t *testing.T) {
+	src, _ := lookupSource("landwirt")
+	site := newFixtureSite(src)
+	site.status = http.StatusForbidden
+	f := newFixtureFetcher(t, site)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	state := newCrawlState(src.Name(), src.DefaultURL(), path)
+
+	err := crawl(context.Background(), src, f, crawlOptions{Details: true, Politeness: politeness{Workers: 1}}, state)
+	if !errors.Is(err, errBlocked) {
+		t.Fatalf("crawl error = %v, want errBlocked", err)
+	}
+
+	saved, err := loadCrawlState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.ListingDone || len(saved.Listings) != 3 || len(saved.DetailsDone) != 0 {
+		t.Errorf("checkpoint has ListingDone %v, %d listings, %d details; want true, 3, 0",
+			saved.ListingDone, len(saved.Listings), len(saved.DetailsDone))
+	}
+}
+
+// TestCrawlRepeatedPage serves the first results page whatever the offset,
+// as a site that ignores it would, each time linking to the next offset,
+// and expects the crawl to stop at the first repeat without duplicating
+// listings or claiming to be complete.
+func TestCrawlRepeatedPage(t *testing.T) {
+	src, _ := lookupSource("landwirt")
+	page, err := os.ReadFile(filepath.Join("testdata", "landwirt", "listing-1.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFixtureFetcher(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		next := "offset=" + strconv.Itoa(offset+landwirtPageSize)
+		w.Write(bytes.Replace(page, []byte("offset=20"), []byte(next), 1))
+	}))
+	state := newCrawlState(src.Name(), src.DefaultURL(), "")
+
+	if err := crawl(context.Background(), src, f, crawlOptions{Politeness: politeness{Workers: 1}}, state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Complete || state.NextPage != 3 || len(state.Listings) != 2 {
+		t.Errorf("Complete %v, NextPage %d, %d listings; want false, 3, 2", state.Complete, state.NextPage, len(state.Listings))
+	}
+}
+
+func TestCrawlMaxItems(t *testing.T) {
+	src, _ := lookupSource("landwirt")
+	f := newFixtureFetcher(t, newFixtureSite(src))
+	state := newCrawlState(src.Name(), src.DefaultURL(), "")
+
+	err := crawl(context.Background(), src, f, crawlOptions{MaxItems: 1, Politeness: politeness{Workers: 1}}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Complete || !state.ListingDone || state.NextPage != 2 || len(state.Listings) != 1 {
+		t.Errorf("Complete %v, ListingDone %v, NextPage %d, %d listings; want false, true, 2, 1",
+			state.Complete, state.ListingDone, state.NextPage, len(state.Listings))
+	}
+}
+
+// TestCrawlEmptyFirstPage serves a first results page with no adverts, as
+// after a redesign that breaks the item selector, and expects the crawl to
+// fail rather than finish complete with nothing found.
+func TestCrawlEmptyFirstPage(t *testing.T) {
+	src, _ := lookupSource("landwirt")
+	f := newFixtureFetcher(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body><p>Our new design</p></body></html>"))
+	}))
+	state := newCrawlState(src.Name(), src.DefaultURL(), "")
+
+	err := crawl(context.Background(), src, f, crawlOptions{Details: true, Politeness: politeness{Workers: 1}}, state)
+	if !errors.Is(err, errNoListings) {
+		t.Errorf("crawl error = %v, want %v", err, errNoListings)
+	}
+	if state.Complete {
+		t.Error("crawl of an empty first page claims to be complete")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvSchemaVersion is written to the schema file beside each CSV export.
+// Bump it whenever csvColumns changes, so that spreadsheets built on the
+// CSV files can tell an export they were not made for.
+const csvSchemaVersion = 1
+
+// csvColumn is one column of the CSV export.
+type csvColumn struct {
+	Name  string
+	value func(l *Listing) string
+}
+
+// csvColumns are the columns of the CSV and XLSX exports, in order.
+// Attributes, whose keys differ from site to site, either go together in
+// the Attributes column or, with -attribute-columns, get a column each
+// after these.
+var csvColumns = []csvColumn{
+	{"Source", func(l *Listing) string { return l.Source }},
+	{"ID", func(l *Listing) string { return l.ID }},
+	{"URL", func(l *Listing) string { return l.URL }},
+	{"Machine", func(l *Listing) string { return formatInt(int(l.MachineID)) }},
+	{"Canonical", func(l *Listing) string { return formatBool(l.Canonical) }},
+	{"First Seen", func(l *Listing) string { return formatTime(l.FirstSeen) }},
+	{"Last Seen", func(l *Listing) string { return formatTime(l.ScrapedAt) }},
+	{"Title", func(l *Listing) string { return l.Title }},
+	{"Make", func(l *Listing) string { return l.Make }},
+	{"Series", func(l *Listing) string { return l.Series }},
+	{"Model", func(l *Listing) string { return l.Model }},
+	{"Year", func(l *Listing) string { return formatInt(l.Year) }},
+	{"Hours", func(l *Listing) string { return formatInt(l.Hours) }},
+	{"Power (hp)", func(l *Listing) string { return formatInt(l.PowerHP) }},
+	{"Power (kW)", func(l *Listing) string { return formatInt(l.PowerKW) }},
+	{"Condition", func(l *Listing) string { return l.Condition }},
+	{"Drive", func(l *Listing) string { return l.Features.Drive }},
+	{"Transmission", func(l *Listing) string { return l.Features.Transmission }},
+	{"PTO Speeds", func(l *Listing) string { return formatSpeeds(l.Features.PTOSpeeds) }},
+	{"Front Loader", func(l *Listing) string { return formatFitted(l.Features.FrontLoader) }},
+	{"Cab", func(l *Listing) string { return formatFitted(l.Features.Cab) }},
+	{"Max Speed (km/h)", func(l *Listing) string { return formatInt(l.Features.MaxSpeedKMH) }},
+	{"Front Tyres", func(l *Listing) string { return l.Features.FrontTyres }},
+	{"Rear Tyres", func(l *Listing) string { return l.Features.RearTyres }},
+	{"Price", func(l *Listing) string { return formatAmount(l.Price.Amount) }},
+	{"Currency", func(l *Listing) string { return l.Price.Currency }},
+	{"VAT Included", func(l *Listing) string { return formatVATIncluded(l.Price) }},
+	{"VAT Rate", func(l *Listing) string { return formatAmount(l.Price.VATRate) }},
+	{"Original Price", func(l *Listing) string { return formatAmount(l.Price.OriginalAmount) }},
+	{"Price Text", func(l *Listing) string { return l.PriceText }},
+	{"Price In Reporting Currency", func(l *Listing) string { return formatAmount(l.PriceInReportingCurrency) }},
+	{"Reporting Currency", func(l *Listing) string { return l.ReportingCurrency }},
+	{"Dealer", func(l *Listing) string { return l.Dealer.Name }},
+	{"Dealer Address", func(l *Listing) string { return l.Dealer.Address }},
+	{"Dealer Postcode", func(l *Listing) string { return l.Dealer.Postcode }},
+	{"Dealer Country", func(l *Listing) string { return l.Dealer.Country }},
+	{"Dealer URL", func(l *Listing) string { return l.Dealer.URL }},
+	{"Contacts", func(l *Listing) string { return formatContacts(l.Dealer.Contacts) }},
+	{"Location", func(l *Listing) string { return l.Location }},
+	{"Postcode", func(l *Listing) string { return l.Place.Postcode }},
+	{"City", func(l *Listing) string { return l.Place.City }},
+	{"Region", func(l *Listing) string { return l.Place.Region }},
+	{"Country", func(l *Listing) string { return l.Place.Country }},
+	{"Distance (km)", formatDistance},
+	{"Placed By", func(l *Listing) string { return l.PlacedBy }},
+	{"Image URL", func(l *Listing) string { return l.ImageURL }},
+	{"Image Hash", func(l *Listing) string { return l.ImageHash }},
+	{"Description", func(l *Listing) string { return l.Description }},
+	{"Attributes", func(l *Listing) string { return formatMap(l.Attributes) }},
+	{"Equipment", func(l *Listing) string { return strings.Join(l.Equipment, "|") }},
+}
+
+// attributePrefix starts the name of each attribute column.
+const attributePrefix = "Attribute: "
+
+// csvSchema describes the columns of a CSV export. It is written beside
+// the export, as listings.schema.json for listings.csv.
+type csvSchema struct {
+	Version int      `json:"version"`
+	Columns []string `json:"columns"`
+}
+
+// exportColumns returns the columns to export: csvColumns, then with
+// attributeColumns a column for each attribute key of listings, sorted.
+func exportColumns(listings []Listing, attributeColumns bool) []csvColumn {
+	if !attributeColumns {
+		return csvColumns
+	}
+	keys := make(map[string]bool)
+	for _, l := range listings {
+		for k := range l.Attributes {
+			keys[k] = true
+		}
+	}
+	columns := slices.DeleteFunc(slices.Clone(csvColumns), func(c csvColumn) bool { return c.Name == "Attributes" })
+	for _, k := range slices.Sorted(maps.Keys(keys)) {
+		columns = append(columns, csvColumn{attributePrefix + k, func(l *Listing) string { return l.Attributes[k] }})
+	}
+	return columns
+}
+
+// schemaPath returns the path of the schema file for the export filename.
+func schemaPath(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".schema.json"
+}
+
+// exportCsv writes listings to filename, creating its directory if needed,
+// and their schema beside it.
+func exportCsv(filename string, listings []Listing, opts exportOptions) error {
+	file, err := createExportFile(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+
+	columns := exportColumns(listings, opts.AttributeColumns)
+	schema := csvSchema{Version: csvSchemaVersion}
+	for _, c := range columns {
+		schema.Columns = append(schema.Columns, c.Name)
+	}
+	if err := writer.Write(schema.Columns); err != nil {
+		return fmt.Errorf("error writing CSV header: %w", err)
+	}
+
+	for i := range listings {
+		row := make([]string, len(columns))
+		for j, c := range columns {
+			row[j] = c.value(&listings[i])
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("error writing CSV record: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(schemaPath(filename), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing CSV schema: %w", err)
+	}
+	return nil
+}
+
+// formatInt leaves unknown (zero) values blank rather than writing 0.
+func formatInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatAmount(f float64) string {
+	if f == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatDistance writes 0 for a listing at home, unlike formatAmount, and
+// leaves listings that could not be placed blank.
+func formatDistance(l *Listing) string {
+	if l.PlacedBy == "" {
+		return ""
+	}
+	return strconv.FormatFloat(l.DistanceKM, 'f', -1, 64)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// formatFitted writes "yes" for equipment a listing mentions and leaves
+// the rest blank, since a listing that does not mention it may still have
+// it.
+func formatFitted(b bool) string {
+	if b {
+		return "yes"
+	}
+	return ""
+}
+
+func formatSpeeds(speeds []int) string {
+	var s []string
+	for _, n := range speeds {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, "/")
+}
+
+func formatVATIncluded(p Price) string {
+	switch {
+	case p.Amount == 0:
+		return ""
+	case p.VATIncluded:
+		return "yes"
+	}
+	return "no"
+}
+
+// formatMap writes m sorted by key, so that exports of the same listings
+// are the same.
+func formatMap(m map[string]string) string {
+	var formatted []string
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		formatted = append(formatted, fmt.Sprintf("%s: %s", k, m[k]))
+	}
+	return strings.Join(formatted, "|")
+}
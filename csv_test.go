@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExportCsv(t *testing.T) {
+	listings := []Listing{
+		{Source: "landwirt", ID: "4470195", Attributes: map[string]string{"Rear tire specifications": "650/65 R42", "Make": "McCormick", "Gears": "32/32"}},
+		{Source: "agriaffaires", ID: "44582981", Attributes: map[string]string{"Front Tire Dimension": "480/65x24", "Make": "Claas"}},
+	}
+	dir := t.TempDir()
+	read := func(name string, attributeColumns bool) ([][]string, []byte) {
+		filename := filepath.Join(dir, name)
+		if err := exportCsv(filename, listings, exportOptions{AttributeColumns: attributeColumns}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records, data
+	}
+
+	records, first := read("a.csv", false)
+	for range 5 {
+		if _, again := read("b.csv", false); !bytes.Equal(first, again) {
+			t.Fatal("exports of the same listings differ")
+		}
+	}
+	attributes := slices.Index(records[0], "Attributes")
+	if got, want := records[1][attributes], "Gears: 32/32|Make: McCormick|Rear tire specifications: 650/65 R42"; got != want {
+		t.Errorf("Attributes = %q, want %q", got, want)
+	}
+
+	var schema csvSchema
+	data, err := os.ReadFile(filepath.Join(dir, "a.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Version != csvSchemaVersion || !slices.Equal(schema.Columns, records[0]) {
+		t.Errorf("schema %+v does not describe header %v", schema, records[0])
+	}
+
+	records, _ = read("c.csv", true)
+	header := records[0]
+	if slices.Contains(header, "Attributes") {
+		t.Error("Attributes column written with -attribute-columns")
+	}
+	want := []string{"Attribute: Front Tire Dimension", "Attribute: Gears", "Attribute: Make", "Attribute: Rear tire specifications"}
+	if got := header[len(header)-len(want):]; !slices.Equal(got, want) {
+		t.Errorf("attribute columns = %v, want %v", got, want)
+	}
+	if got := records[2][len(header)-2]; got != "Claas" {
+		t.Errorf("agriaffaires Make column = %q", got)
+	}
+}
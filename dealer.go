@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// Dealer is the seller of a listing. Dealers are stored once per source
+// and shared by all their listings.
+type Dealer struct {
+	ID       string // see dealerKey; set when read back from the store
+	Name     string
+	Address  string // postal address on one line
+	Postcode string
+	Country  string // ISO code, or as the site gives it if it is not a known country
+	Contacts []Contact
+	URL      string // the dealer's page on the site
+}
+
+// dealerKey identifies a dealer within a source by its name and, when
+// known, postcode: branches of a chain share a name. Case, punctuation
+// and spacing are ignored so small differences between pages still match.
+// It returns "" for a dealer without a name.
+func dealerKey(d Dealer) string {
+	key := slug(d.Name)
+	if key == "" {
+		return ""
+	}
+	if pc := slug(d.Postcode); pc != "" {
+		key += "@" + pc
+	}
+	return key
+}
+
+// slug lowercases s and joins its runs of letters and digits with "-".
+func slug(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// joinLines turns a multi-line address into one line.
+func joinLines(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, ", ")
+}
+
+const upsertDealer = `INSERT INTO dealers
+	(source, id, name, address, postcode, country, contacts, url, first_seen, last_seen)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (source, id) DO UPDATE SET
+	name = excluded.name,
+	address = CASE WHEN excluded.address = '' THEN dealers.address ELSE excluded.address END,
+	postcode = CASE WHEN excluded.postcode = '' THEN dealers.postcode ELSE excluded.postcode END,
+	country = CASE WHEN excluded.country = '' THEN dealers.country ELSE excluded.country END,
+	contacts = CASE WHEN excluded.contacts IN ('[]', 'null') THEN dealers.contacts ELSE excluded.contacts END,
+	url = CASE WHEN excluded.url = '' THEN dealers.url ELSE excluded.url END,
+	last_seen = MAX(dealers.last_seen, excluded.last_seen)`
+
+// queryDealers returns the dealers of a source, or of every source if it
+// is "", keyed by source and ID.
+func (s *store) queryDealers(source string) (map[[2]string]Dealer, error) {
+	rows, err := s.db.Query(`SELECT source, id, name, address, postcode, country, contacts, url
+		FROM dealers WHERE ? = '' OR source = ?`, source, source)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dealers: %w", err)
+	}
+	defer rows.Close()
+
+	dealers := make(map[[2]string]Dealer)
+	for rows.Next() {
+		var (
+			src, contacts string
+			d             Dealer
+		)
+		if err := rows.Scan(&src, &d.ID, &d.Name, &d.Address, &d.Postcode, &d.Country, &contacts, &d.URL); err != nil {
+			return nil, fmt.Errorf("error reading dealers: %w", err)
+		}
+		if err := json.Unmarshal([]byte(contacts), &d.Contacts); err != nil {
+			return nil, fmt.Errorf("dealer %s/%s: bad contacts: %w", src, d.ID, err)
+		}
+		dealers[[2]string{src, d.ID}] = d
+	}
+	return dealers, rows.Err()
+}
+
+// dealerSummary is a dealer's active inventory.
+type dealerSummary struct {
+	Source   string
+	Dealer   Dealer
+	Listings int
+	Prices   []priceRange // one per currency
+}
+
+// priceRange is the span of a dealer's asking prices in one currency.
+type priceRange struct {
+	Currency string
+	Min, Max float64
+}
+
+// dealerSummaries counts the active listings of each dealer of a source, or
+// of every source if it is "", with their price ranges. Dealers with the
+// most listings come first.
+func (s *store) dealerSummaries(source string) ([]dealerSummary, error) {
+	dealers, err := s.queryDealers(source)
+	if err != nil {
+		return nil, err
+	}
+	// Listings on request have no amount and are counted but left out of
+	// the price range. A machine the dealer advertises twice, in two
+	// categories say, is counted once.
+	rows, err := s.db.Query(`SELECT d.source, d.id,
+		COUNT(DISTINCT CASE WHEN l.machine_id = 0 THEN 'listing ' || l.id ELSE l.machine_id END), COALESCE(l.price_currency, ''),
+		MIN(NULLIF(l.price_amount, 0)), MAX(NULLIF(l.price_amount, 0))
+		FROM dealers d LEFT JOIN listings l
+			ON l.source = d.source AND l.dealer_id = d.id AND l.status = ?
+		WHERE ? = '' OR d.source = ?
+		GROUP BY d.source, d.id, l.price_currency
+		ORDER BY d.source, d.id, l.price_currency`, statusActive, source, source)
+	if err != nil {
+		return nil, fmt.Errorf("error summarising dealers: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []dealerSummary
+	for rows.Next() {
+		var (
+			src, id, currency string
+			count             int
+			min, max          sql.NullFloat64
+		)
+		if err := rows.Scan(&src, &id, &count, &currency, &min, &max); err != nil {
+			return nil, fmt.Errorf("error summarising dealers: %w", err)
+		}
+		n := len(summaries)
+		if n == 0 || summaries[n-1].Source != src || summaries[n-1].Dealer.ID != id {
+			summaries = append(summaries, dealerSummary{Source: src, Dealer: dealers[[2]string{src, id}]})
+			n++
+		}
+		sum := &summaries[n-1]
+		sum.Listings += count
+		if max.Valid {
+			sum.Prices = append(sum.Prices, priceRange{Currency: currency, Min: min.Float64, Max: max.Float64})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(summaries, func(a, b dealerSummary) int {
+		if a.Listings != b.Listings {
+			return b.Listings - a.Listings
+		}
+		return strings.Compare(strings.ToLower(a.Dealer.Name), strings.ToLower(b.Dealer.Name))
+	})
+	return summaries, nil
+}
+
+func runDealers(args []string) error {
+	fs := flag.NewFlagSet("dealers", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDB, "listing store to read")
+	sourceName := fs.String("source", "", "only list dealers from this source")
+	fs.Parse(args)
+
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	summaries, err := st.dealerSummaries(*sourceName)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Dealer\tSource\tPostcode\tCountry\tListings\tPrices\tContact")
+	for _, s := range summaries {
+		var prices []string
+		for _, p := range s.Prices {
+			amount := formatAmount(p.Min)
+			if p.Max != p.Min {
+				amount += "-" + formatAmount(p.Max)
+			}
+			prices = append(prices, strings.TrimSpace(amount+" "+p.Currency))
+		}
+		contact := ""
+		if len(s.Dealer.Contacts) > 0 {
+			contact = formatContacts(s.Dealer.Contacts[:1])
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", s.Dealer.Name, s.Source, s.Dealer.Postcode,
+			s.Dealer.Country, s.Listings, strings.Join(prices, ", "), contact)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDealerKey(t *testing.T) {
+	tests := []struct {
+		dealer Dealer
+		want   string
+	}{
+		{Dealer{Name: "Lagerhaus Technik-Center", Postcode: "4600"}, "lagerhaus-technik-center@4600"},
+		{Dealer{Name: " LAGERHAUS  Technik - Center "}, "lagerhaus-technik-center"},
+		{Dealer{Name: "West Country Classics", Postcode: "EX2 8PW"}, "west-country-classics@ex2-8pw"},
+		{Dealer{Postcode: "4600"}, ""},
+	}
+	for _, tt := range tests {
+		if got := dealerKey(tt.dealer); got != tt.want {
+			t.Errorf("dealerKey(%+v) = %q, want %q", tt.dealer, got, tt.want)
+		}
+	}
+}
+
+// TestDealerSummaries stores the landwirt fixtures, two of which are sold by
+// the same dealer, first from the results pages alone and then with their
+// detail pages.
+func TestDealerSummaries(t *testing.T) {
+	st, err := openStore(filepath.Join(t.TempDir(), "tractors.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	listings := fixtureListings(t, "landwirt")
+	now := time.Now()
+	for i := range listings {
+		listings[i].ScrapedAt = now
+	}
+	if err := st.upsertListings(listings); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := lookupSource("landwirt")
+	for i := range listings {
+		src.ParseDetail(loadFixture(t, filepath.Join("testdata", "landwirt", "detail-"+listings[i].ID+".html")), &listings[i])
+	}
+	if err := st.upsertListings(listings); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := st.dealerSummaries("landwirt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("got %d dealers, want 2: %+v", len(summaries), summaries)
+	}
+	s := summaries[0]
+	if s.Dealer.Name != "Lagerhaus Technik-Center" || s.Listings != 2 {
+		t.Errorf("first dealer = %s with %d listings, want Lagerhaus Technik-Center with 2", s.Dealer.Name, s.Listings)
+	}
+	// The other listing is on request and has no price.
+	if len(s.Prices) != 1 || s.Prices[0] != (priceRange{Currency: "EUR", Min: 42000, Max: 42000}) {
+		t.Errorf("prices = %+v", s.Prices)
+	}
+	if s.Dealer.Address != "Salzburger Straße 50, 4600 Wels, Austria" || len(s.Dealer.Contacts) != 2 {
+		t.Errorf("dealer details not stored: %+v", s.Dealer)
+	}
+
+	// A later crawl without details keeps what the detail pages gave.
+	if err := st.upsertListings(fixtureListings(t, "landwirt")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := st.queryListings(listingFilter{Source: "landwirt", ID: "4491022"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Dealer.ID != "lagerhaus-technik-center@4600" || len(stored[0].Dealer.Contacts) != 2 {
+		t.Errorf("stored listing dealer = %+v", stored)
+	}
+}
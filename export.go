@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exportFormat writes listings to a file in one format. The format's name
+// is also the extension of its files.
+type exportFormat struct {
+	summary string
+	write   func(filename string, listings []Listing, opts exportOptions) error
+}
+
+// exportOptions are the export flags some formats use.
+type exportOptions struct {
+	// AttributeColumns gives each attribute a column of its own in
+	// formats with columns, rather than writing them all in one.
+	AttributeColumns bool
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":     {"comma-separated values, with the columns described in a .schema.json beside it", exportCsv},
+	"jsonl":   {"one JSON object per line, attributes, equipment and contacts kept as they are", exportJSONLines},
+	"parquet": {"Parquet, one typed column per field, nested fields as JSON", exportParquet},
+	"xlsx":    {"Excel workbook with a sheet for each source", exportXlsx},
+}
+
+// exportFormatFor returns the format called name or, if name is "", the
+// format the extension of filename names, or csv if there is no filename
+// either.
+func exportFormatFor(name, filename string) (string, error) {
+	switch {
+	case name != "":
+		if _, ok := exportFormats[name]; !ok {
+			return "", fmt.Errorf("unknown -format %q (available: %s)", name, strings.Join(sortedKeys(exportFormats), ", "))
+		}
+	case filename == "":
+		name = "csv"
+	default:
+		name = strings.TrimPrefix(filepath.Ext(filename), ".")
+		if _, ok := exportFormats[name]; !ok {
+			return "", fmt.Errorf("unknown export file extension %q in %s: pass -format or use one of .%s",
+				filepath.Ext(filename), filename, strings.Join(sortedKeys(exportFormats), ", ."))
+		}
+	}
+	return name, nil
+}
+
+// exportFormatUsage describes the formats for the -format flag.
+func exportFormatUsage() string {
+	var b strings.Builder
+	b.WriteString("file format (default: the extension of -o, or csv):")
+	for _, name := range sortedKeys(exportFormats) {
+		fmt.Fprintf(&b, "\n  %s: %s", name, exportFormats[name].summary)
+	}
+	return b.String()
+}
+
+// createExportFile creates filename and its directory.
+func createExportFile(filename string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating results directory: %w", err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error creating export file: %w", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// exportListings are listings from two sources with nested fields set.
+func exportListings() []Listing {
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []Listing{
+		{
+			Source: "agriaffaires", ID: "44582981", Title: "Claas Arion 640", Year: 2016, FirstSeen: seen, ScrapedAt: seen,
+			Price:      Price{Amount: 58000, Currency: "GBP"},
+			Features:   Features{FrontTyres: "480/65 R24", PTOSpeeds: []int{540, 1000}},
+			Attributes: map[string]string{"Front Tire Dimension": "480/65x24"},
+		},
+		{
+			Source: "landwirt", ID: "4483344", Title: "McCormick X4.70", Year: 2014, Hours: 4050, FirstSeen: seen, ScrapedAt: seen,
+			Dealer:    Dealer{Name: "Landbrukssalg AS", Contacts: []Contact{{Type: contactMobile, Number: "+4791234567", Raw: "912 34 567"}}},
+			Equipment: []string{"Front loader", "Air conditioner", "Top speed in km/h: 40 km/h"},
+			Features:  Features{FrontLoader: true, Cab: true, MaxSpeedKMH: 40},
+		},
+		{Source: "landwirt", ID: "4491022", Title: "Fordson Major", Year: 1956},
+	}
+}
+
+func TestExportFormatFor(t *testing.T) {
+	tests := []struct{ format, output, want string }{
+		{"", "", "csv"},
+		{"", "out/tractors.xlsx", "xlsx"},
+		{"jsonl", "out/tractors.json", "jsonl"},
+		{"csv", "out/tractors.txt", "csv"},
+		{"parquet", "", "parquet"},
+	}
+	for _, tt := range tests {
+		if got, err := exportFormatFor(tt.format, tt.output); err != nil || got != tt.want {
+			t.Errorf("exportFormatFor(%q, %q) = %q, %v; want %q", tt.format, tt.output, got, err, tt.want)
+		}
+	}
+	for _, bad := range []struct{ format, output string }{{"xls", ""}, {"", "out/tractors.txt"}, {"", "out/tractors"}} {
+		if got, err := exportFormatFor(bad.format, bad.output); err == nil {
+			t.Errorf("exportFormatFor(%q, %q) = %q, want an error", bad.format, bad.output, got)
+		}
+	}
+}
+
+func TestExportJSONLines(t *testing.T) {
+	listings := exportListings()
+	filename := filepath.Join(t.TempDir(), "out", "listings.jsonl")
+	if err := exportJSONLines(filename, listings, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var got []Listing
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var l Listing
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("line %d: %v", len(got)+1, err)
+		}
+		got = append(got, l)
+	}
+	if !reflect.DeepEqual(got, listings) {
+		t.Errorf("read back %+v\nwant %+v", got, listings)
+	}
+}
+
+func TestExportXlsx(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "listings.xlsx")
+	if err := exportXlsx(filename, exportListings(), exportOptions{AttributeColumns: true}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got := f.GetSheetList(); !slices.Equal(got, []string{"agriaffaires", "landwirt"}) {
+		t.Fatalf("sheets = %v", got)
+	}
+	rows, err := f.GetRows("landwirt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][1] != "4483344" || rows[2][1] != "4491022" {
+		t.Errorf("landwirt rows = %v", rows)
+	}
+	// Attribute columns are only those of the sheet's own listings.
+	if rows, _ := f.GetRows("agriaffaires"); rows[0][len(rows[0])-1] != "Attribute: Front Tire Dimension" {
+		t.Errorf("agriaffaires header ends %q", rows[0][len(rows[0])-1])
+	}
+	year := slices.Index(rows[0], "Year")
+	cell, _ := excelize.CoordinatesToCellName(year+1, 2)
+	if v, err := f.GetCellValue("landwirt", cell); err != nil || v != "2014" {
+		t.Errorf("Year = %q, %v", v, err)
+	}
+	// Numbers are written as numbers, not text.
+	if typ, err := f.GetCellType("landwirt", cell); err != nil || typ == excelize.CellTypeSharedString || typ == excelize.CellTypeInlineString {
+		t.Errorf("Year cell type = %v, %v", typ, err)
+	}
+}
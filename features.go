@@ -0,0 +1,243 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Features are the specifications buyers compare tractors by, in the same
+// terms whatever the site calls them. They are read from the fields a site
+// definition maps to them (see featureFields) and, where those say
+// nothing, from the title, description and equipment list. Zero values
+// are unknown: a listing without FrontLoader may simply not mention it.
+type Features struct {
+	Drive        string // driveTwoWheel or driveFourWheel
+	Transmission string // one of transmissionTypes
+	PTOSpeeds    []int  // rpm, ascending
+	FrontLoader  bool
+	Cab          bool
+	MaxSpeedKMH  int
+	FrontTyres   string // size as "480/65 R24", "16.9 R38" or "7.50-16"
+	RearTyres    string
+}
+
+const (
+	driveTwoWheel  = "2WD"
+	driveFourWheel = "4WD"
+)
+
+// featureFields are the listing fields, besides listingFields, that a site
+// definition can map its own fields, table rows and equipment to.
+var featureFields = []string{
+	"drive", "transmission", "pto", "front_loader", "cab", "max_speed", "front_tyres", "rear_tyres",
+}
+
+// transmissionType is a kind of transmission and the words that give it
+// away.
+type transmissionType struct {
+	Name string
+	re   *regexp.Regexp
+}
+
+// transmissionTypes are the kinds of transmission told apart. They are
+// tried in order: "semi-powershift" must not be read as powershift.
+var transmissionTypes = []transmissionType{
+	{"cvt", regexp.MustCompile(`(?i)\b(cvt|cvx|ivt|vario|stepless|continuously variable|auto ?powr|s-?matic|dyna-?vt|vt-?drive)\b`)},
+	{"semi-powershift", regexp.MustCompile(`(?i)\b(semi[- ]?power ?shift|partial power ?shift|dyna-?[246]|power ?quad|power ?plus|quad ?shift|speed ?four)\b`)},
+	{"powershift", regexp.MustCompile(`(?i)\b(full ?power ?shift|power ?shift|power ?command|dyna-?shift)\b`)},
+	{"hydrostatic", regexp.MustCompile(`(?i)\b(hydrostatic|hst)\b`)},
+	{"manual", regexp.MustCompile(`(?i)\b(manual|mechanical|synchro\w*|syncro\w*|constant mesh)\b`)},
+}
+
+var (
+	fourWheelRe   = regexp.MustCompile(`(?i)\b(4wd|4x4|awd|mfwd|fwa|four[- ]wheel[- ]drive|all[- ]wheel[- ]drive|allrad\w*)\b`)
+	twoWheelRe    = regexp.MustCompile(`(?i)\b(2wd|4x2|two[- ]wheel[- ]drive|rear[- ]wheel[- ]drive)\b`)
+	ptoRe         = regexp.MustCompile(`(?i)\b(pto|power take-off|zapfwelle)\b[^.;\n]{0,40}`)
+	ptoSpeedRe    = regexp.MustCompile(`\b(540|750|1000)(?:\s*e(?:co)?)?\b`)
+	frontLoaderRe = regexp.MustCompile(`(?i)\b(front[- ]?loader|frontlader|chargeur frontal)\b`)
+	cabRe         = regexp.MustCompile(`(?i)\b(cab|cabin|kabine|cabine|air[- ]?con\w*|klima\w*)\b`)
+	speedRe       = regexp.MustCompile(`(?i)\b(\d{2})\s*(?:km/h|kph|kmh)\b`)
+	negationRe    = regexp.MustCompile(`(?i)\b(no|without|ohne|sans|not)\s+$`)
+	// tyreRe matches "480/65x24", "650/65 R42", "16.9R38" and "7.50-16".
+	tyreRe = regexp.MustCompile(`(?i)\b(\d{1,3}(?:[.,]\d{1,2})?)\s*(?:/\s*(\d{2}))?\s*(?:-|x|\s)?\s*(R)?\s*(\d{2})\b`)
+)
+
+// set reads the value of a featureFields field.
+func (f *Features) set(name, v string) {
+	switch name {
+	case "drive":
+		f.Drive = parseDrive(v)
+	case "transmission":
+		f.Transmission = parseTransmission(v)
+	case "pto":
+		f.PTOSpeeds = parsePTOSpeeds(v)
+	case "front_loader":
+		f.FrontLoader = f.FrontLoader || parseFlag(v)
+	case "cab":
+		f.Cab = f.Cab || parseFlag(v)
+	case "max_speed":
+		f.MaxSpeedKMH = parseInt(v)
+	case "front_tyres":
+		f.FrontTyres = parseTyreSize(v)
+	case "rear_tyres":
+		f.RearTyres = parseTyreSize(v)
+	}
+}
+
+// scan fills in the features text mentions that are still unknown.
+func (f *Features) scan(text string) {
+	if f.Drive == "" {
+		f.Drive = parseDrive(text)
+	}
+	if f.Transmission == "" {
+		f.Transmission = parseTransmission(text)
+	}
+	if f.PTOSpeeds == nil {
+		for _, m := range ptoRe.FindAllString(text, -1) {
+			f.PTOSpeeds = mergeSpeeds(f.PTOSpeeds, parsePTOSpeeds(m))
+		}
+	}
+	f.FrontLoader = f.FrontLoader || mentions(frontLoaderRe, text)
+	f.Cab = f.Cab || mentions(cabRe, text)
+	if f.MaxSpeedKMH == 0 {
+		if m := speedRe.FindStringSubmatch(text); m != nil {
+			f.MaxSpeedKMH, _ = strconv.Atoi(m[1])
+		}
+	}
+}
+
+func parseDrive(s string) string {
+	switch {
+	case fourWheelRe.MatchString(s):
+		return driveFourWheel
+	case twoWheelRe.MatchString(s):
+		return driveTwoWheel
+	}
+	return ""
+}
+
+func parseTransmission(s string) string {
+	for _, t := range transmissionTypes {
+		if t.re.MatchString(s) {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// parsePTOSpeeds reads the standard PTO speeds mentioned in s, as in
+// "540/1000" or "540, 540E, 1000".
+func parsePTOSpeeds(s string) []int {
+	var speeds []int
+	for _, m := range ptoSpeedRe.FindAllStringSubmatch(s, -1) {
+		n, _ := strconv.Atoi(m[1])
+		speeds = mergeSpeeds(speeds, []int{n})
+	}
+	return speeds
+}
+
+func mergeSpeeds(a, b []int) []int {
+	for _, n := range b {
+		if !slices.Contains(a, n) {
+			a = append(a, n)
+		}
+	}
+	slices.Sort(a)
+	return a
+}
+
+// parseFlag reads a yes/no value. Equipment lists give only the name of
+// what is fitted, so anything that is not a "no" counts as yes.
+func parseFlag(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "no", "nein", "non", "none", "-", "n/a":
+		return false
+	}
+	return !negationRe.MatchString(strings.Fields(s)[0] + " ")
+}
+
+// mentions reports whether text mentions what re matches other than to say
+// it is missing, as in "no cab".
+func mentions(re *regexp.Regexp, text string) bool {
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		if !negationRe.MatchString(text[:loc[0]]) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTyreSize writes a tyre size the same way whatever the site's
+// punctuation: metric sizes, which are radial, as "480/65 R24", and others
+// as "16.9 R38" or "7.50-16".
+func parseTyreSize(s string) string {
+	m := tyreRe.FindStringSubmatch(s)
+	if m == nil {
+		return ""
+	}
+	width := strings.ReplaceAll(m[1], ",", ".")
+	switch {
+	case m[2] != "":
+		return fmt.Sprintf("%s/%s R%s", width, m[2], m[4])
+	case m[3] != "":
+		return fmt.Sprintf("%s R%s", width, m[4])
+	}
+	return width + "-" + m[4]
+}
+
+// isZero reports whether nothing is known of the features.
+func (f Features) isZero() bool {
+	return f.Drive == "" && f.Transmission == "" && len(f.PTOSpeeds) == 0 && !f.FrontLoader &&
+		!f.Cab && f.MaxSpeedKMH == 0 && f.FrontTyres == "" && f.RearTyres == ""
+}
+
+// featureFilter selects listings by their features. Unlike searchFilter it
+// is strict: a listing that does not say is left out.
+type featureFilter struct {
+	Drive        string
+	Transmission string
+	PTOSpeed     int
+	FrontLoader  bool
+	Cab          bool
+	MinSpeedKMH  int
+}
+
+// addFlags defines the export flags that fill in f.
+func (f *featureFilter) addFlags(fs *flag.FlagSet) {
+	var names []string
+	for _, t := range transmissionTypes {
+		names = append(names, t.Name)
+	}
+	fs.StringVar(&f.Drive, "drive", "", "only export tractors with this drive: 2WD or 4WD")
+	fs.StringVar(&f.Transmission, "transmission", "", "only export tractors with this transmission: "+strings.Join(names, ", "))
+	fs.IntVar(&f.PTOSpeed, "pto", 0, "only export tractors with this PTO speed, e.g. 1000")
+	fs.BoolVar(&f.FrontLoader, "front-loader", false, "only export tractors with a front loader")
+	fs.BoolVar(&f.Cab, "cab", false, "only export tractors with a cab")
+	fs.IntVar(&f.MinSpeedKMH, "min-speed", 0, "only export tractors with at least this top speed in km/h")
+}
+
+// validate checks the filter and normalises its drive.
+func (f *featureFilter) validate() error {
+	if f.Drive != "" {
+		if f.Drive = parseDrive(f.Drive); f.Drive == "" {
+			return fmt.Errorf("invalid -drive: want 2WD or 4WD")
+		}
+	}
+	if f.Transmission != "" && !slices.ContainsFunc(transmissionTypes, func(t transmissionType) bool { return t.Name == f.Transmission }) {
+		return fmt.Errorf("unknown -transmission %q", f.Transmission)
+	}
+	return nil
+}
+
+func (f featureFilter) matches(l *Listing) bool {
+	x := l.Features
+	return (f.Drive == "" || x.Drive == f.Drive) &&
+		(f.Transmission == "" || x.Transmission == f.Transmission) &&
+		(f.PTOSpeed == 0 || slices.Contains(x.PTOSpeeds, f.PTOSpeed)) &&
+		(!f.FrontLoader || x.FrontLoader) && (!f.Cab || x.Cab) &&
+		(f.MinSpeedKMH == 0 || x.MaxSpeedKMH >= f.MinSpeedKMH)
+}
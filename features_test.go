@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTyreSize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"480/65x24", "480/65 R24"},
+		{"650/65 R42", "650/65 R42"},
+		{"540/65R30 Michelin", "540/65 R30"},
+		{"16.9R38", "16.9 R38"},
+		{"16,9 R 34", "16.9 R34"},
+		{"7.50-16", "7.50-16"},
+		{"Michelin", ""},
+	}
+	for _, tt := range tests {
+		if got := parseTyreSize(tt.in); got != tt.want {
+			t.Errorf("parseTyreSize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFeaturesScan(t *testing.T) {
+	tests := []struct {
+		text string
+		want Features
+	}{
+		{"McCormick X7.670, Vario, 4WD, cab with air con", Features{Drive: driveFourWheel, Transmission: "cvt", Cab: true}},
+		{"Dyna-4, front linkage, PTO 540/1000", Features{Transmission: "semi-powershift", PTOSpeeds: []int{540, 1000}}},
+		{"Full powershift, 50 km/h, 540E and 1000 PTO", Features{Transmission: "powershift", MaxSpeedKMH: 50}},
+		{"2WD, no cab, without front loader", Features{Drive: driveTwoWheel}},
+		{"Frontlader Stoll, Kabine", Features{FrontLoader: true, Cab: true}},
+	}
+	for _, tt := range tests {
+		var f Features
+		f.scan(tt.text)
+		if f.Drive != tt.want.Drive || f.Transmission != tt.want.Transmission || !slices.Equal(f.PTOSpeeds, tt.want.PTOSpeeds) ||
+			f.FrontLoader != tt.want.FrontLoader || f.Cab != tt.want.Cab || f.MaxSpeedKMH != tt.want.MaxSpeedKMH {
+			t.Errorf("scan(%q) = %+v, want %+v", tt.text, f, tt.want)
+		}
+	}
+
+	// A mapped field is not overridden by the text.
+	f := Features{Transmission: "manual"}
+	f.scan("Powershift")
+	if f.Transmission != "manual" {
+		t.Errorf("mapped transmission replaced by %q", f.Transmission)
+	}
+}
+
+func TestFeatureFilter(t *testing.T) {
+	listings := []Listing{
+		{ID: "4483344", Features: Features{FrontLoader: true, Cab: true, MaxSpeedKMH: 40}},
+		{ID: "4470195", Features: Features{Drive: driveFourWheel, MaxSpeedKMH: 50}},
+		{ID: "45219407", Features: Features{Transmission: "semi-powershift", PTOSpeeds: []int{540, 1000}}},
+		{ID: "44698339"},
+	}
+	ids := func(f featureFilter) []string {
+		var ids []string
+		for _, l := range listings {
+			if f.matches(&l) {
+				ids = append(ids, l.ID)
+			}
+		}
+		return ids
+	}
+
+	if got := ids(featureFilter{FrontLoader: true, MinSpeedKMH: 40}); !slices.Equal(got, []string{"4483344"}) {
+		t.Errorf("front loader, 40 km/h: %v", got)
+	}
+	if got := ids(featureFilter{Transmission: "semi-powershift", PTOSpeed: 1000}); !slices.Equal(got, []string{"45219407"}) {
+		t.Errorf("semi-powershift, 1000 PTO: %v", got)
+	}
+	// Listings that do not give their drive are left out.
+	if got := ids(featureFilter{Drive: driveFourWheel}); !slices.Equal(got, []string{"4470195"}) {
+		t.Errorf("4WD: %v", got)
+	}
+
+	f := featureFilter{Drive: "4x4"}
+	if err := f.validate(); err != nil || f.Drive != driveFourWheel {
+		t.Errorf("validate = %v, drive %q", err, f.Drive)
+	}
+	for _, bad := range []featureFilter{{Drive: "tracks"}, {Transmission: "automatic"}} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
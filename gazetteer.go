@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//go:embed gazetteer.csv
+var builtinGazetteer []byte
+
+// Kinds of gazetteer entry, from the most to the least precise.
+const (
+	placedByPostcode = "postcode"
+	placedByCity     = "city"
+	placedByRegion   = "region"
+	placedByCountry  = "country"
+)
+
+type coord struct {
+	Lat, Lon float64
+}
+
+type gazetteerKey struct {
+	Country, Kind, Key string
+}
+
+// gazetteer places locations on the map offline, approximately: see
+// gazetteer.csv.
+type gazetteer map[gazetteerKey]coord
+
+// loadGazetteer reads the built-in gazetteer and then each file in extra,
+// whose rows are added to or replace the built-in ones.
+func loadGazetteer(extra ...string) (gazetteer, error) {
+	g := make(gazetteer)
+	if err := g.read(bytes.NewReader(builtinGazetteer)); err != nil {
+		return nil, fmt.Errorf("error in built-in gazetteer: %w", err)
+	}
+	for _, path := range extra {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening gazetteer: %w", err)
+		}
+		err = g.read(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error in gazetteer %s: %w", path, err)
+		}
+	}
+	return g, nil
+}
+
+// read adds the rows of a gazetteer file: country,kind,key,lat,lon with a
+// header row.
+func (g gazetteer) read(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 5
+	records, err := cr.ReadAll()
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		if i == 0 {
+			continue
+		}
+		country := countryCode(rec[0])
+		if country == "" {
+			return fmt.Errorf("row %d: unknown country %q", i+1, rec[0])
+		}
+		kind := rec[1]
+		switch kind {
+		case placedByPostcode, placedByCity, placedByRegion, placedByCountry:
+		default:
+			return fmt.Errorf("row %d: unknown kind %q", i+1, kind)
+		}
+		lat, err1 := strconv.ParseFloat(rec[3], 64)
+		lon, err2 := strconv.ParseFloat(rec[4], 64)
+		if err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+			return fmt.Errorf("row %d: invalid coordinates %s,%s", i+1, rec[3], rec[4])
+		}
+		g[gazetteerKey{country, kind, gazetteerName(kind, rec[2])}] = coord{lat, lon}
+	}
+	return nil
+}
+
+// gazetteerName normalises a key for lookup: postcodes lose their spaces
+// and names their case.
+func gazetteerName(kind, key string) string {
+	if kind == placedByPostcode {
+		return strings.ToUpper(strings.Join(strings.Fields(key), ""))
+	}
+	return strings.ToLower(strings.Join(strings.Fields(key), " "))
+}
+
+// locate places p by the most precise entry that matches: the longest
+// postcode prefix, then the town (or, for names such as "H Trondheim",
+// its last word), the region and finally the country. by is the kind of
+// entry used; ok is false if p has no known country.
+func (g gazetteer) locate(p Place) (c coord, by string, ok bool) {
+	if p.Country == "" {
+		return coord{}, "", false
+	}
+	lookup := func(kind, key string) bool {
+		c, ok = g[gazetteerKey{p.Country, kind, key}]
+		by = kind
+		return ok && key != ""
+	}
+
+	postcode := gazetteerName(placedByPostcode, p.Postcode)
+	for i := len(postcode); i > 0; i-- {
+		if lookup(placedByPostcode, postcode[:i]) {
+			return c, by, true
+		}
+	}
+	city := gazetteerName(placedByCity, p.City)
+	if lookup(placedByCity, city) {
+		return c, by, true
+	}
+	if i := strings.LastIndexByte(city, ' '); i >= 0 && lookup(placedByCity, city[i+1:]) {
+		return c, by, true
+	}
+	if lookup(placedByRegion, gazetteerName(placedByRegion, p.Region)) {
+		return c, by, true
+	}
+	c, ok = g[gazetteerKey{p.Country, placedByCountry, ""}]
+	return c, placedByCountry, ok
+}
+
+// parseHome reads a home location given as a country and a postcode or
+// town: "AT 4600", "GB EX2 8PW" or "DE München".
+func (g gazetteer) parseHome(s string) (coord, error) {
+	country, rest, _ := strings.Cut(strings.TrimSpace(s), " ")
+	p := Place{Country: countryCode(country)}
+	rest = strings.TrimSpace(rest)
+	if p.Country == "" || rest == "" {
+		return coord{}, fmt.Errorf("invalid home %q: want a country and a postcode or town, e.g. \"AT 4600\"", s)
+	}
+	if strings.ContainsAny(rest, "0123456789") {
+		p.Postcode = rest
+	} else {
+		p.City = rest
+	}
+	c, by, _ := g.locate(p)
+	if by == placedByCountry {
+		return coord{}, fmt.Errorf("home %q is not in the gazetteer; add it with -gazetteer", s)
+	}
+	return c, nil
+}
+
+// distanceKM is the great-circle distance between a and b.
+func distanceKM(a, b coord) float64 {
+	const earthRadiusKM = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(b.Lat-a.Lat), rad(b.Lon-a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Sqrt(h))
+}
+
+// setDistances fills in the distance of each listing from home. Listings
+// are placed by their location and, failing that, by their dealer's
+// postcode and country.
+func (g gazetteer) setDistances(listings []Listing, home coord) {
+	for i := range listings {
+		l := &listings[i]
+		c, by, ok := g.locate(l.Place)
+		if (!ok || by == placedByCountry) && l.Dealer.Postcode != "" {
+			if dc, dby, dok := g.locate(Place{Postcode: l.Dealer.Postcode, Country: l.Dealer.Country}); dok && dby != placedByCountry {
+				c, by, ok = dc, dby, true
+			}
+		}
+		if !ok {
+			l.DistanceKM, l.PlacedBy = 0, ""
+			continue
+		}
+		l.DistanceKM = math.Round(distanceKM(home, c))
+		l.PlacedBy = by
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDistanceKM(t *testing.T) {
+	london, paris := coord{51.5074, -0.1278}, coord{48.8566, 2.3522}
+	if d := distanceKM(london, paris); math.Abs(d-344) > 2 {
+		t.Errorf("London-Paris = %.0f km, want about 344", d)
+	}
+	if d := distanceKM(paris, paris); d != 0 {
+		t.Errorf("Paris-Paris = %f", d)
+	}
+}
+
+func TestGazetteerLocate(t *testing.T) {
+	g, err := loadGazetteer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		place  Place
+		wantBy string
+		want   coord
+	}{
+		{Place{Postcode: "4600", City: "Wels", Country: "AT"}, placedByPostcode, coord{48.16, 14.03}},
+		{Place{Postcode: "ex2 8pw", Country: "GB"}, placedByPostcode, coord{50.72, -3.53}},
+		{Place{City: "H Trondheim", Region: "Tromsø", Country: "NO"}, placedByCity, coord{63.43, 10.40}},
+		{Place{Region: "Somerset", Country: "GB"}, placedByRegion, coord{51.10, -3.00}},
+		{Place{Region: "Nowhere", Country: "FR"}, placedByCountry, coord{46.60, 2.40}},
+	}
+	for _, tt := range tests {
+		c, by, ok := g.locate(tt.place)
+		if !ok || by != tt.wantBy || c != tt.want {
+			t.Errorf("locate(%+v) = %v, %q, %v; want %v by %s", tt.place, c, by, ok, tt.want, tt.wantBy)
+		}
+	}
+	if _, _, ok := g.locate(Place{Postcode: "4600", City: "Wels"}); ok {
+		t.Error("placed a location without a country")
+	}
+}
+
+func TestWithDistances(t *testing.T) {
+	var listings []Listing
+	for _, name := range sourceNames() {
+		listings = append(listings, fixtureListings(t, name)...)
+	}
+	src, _ := lookupSource("landwirt")
+	for i := range listings {
+		if listings[i].Source == "landwirt" {
+			src.ParseDetail(loadFixture(t, filepath.Join("testdata", "landwirt", "detail-"+listings[i].ID+".html")), &listings[i])
+		}
+	}
+
+	near, err := withDistances(listings, "AT 4020", 150, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the two adverts from Wels, 25 km from Linz, are within range.
+	if len(near) != 2 || near[0].Place.City != "Wels" || near[0].DistanceKM != 25 || near[0].PlacedBy != placedByPostcode {
+		t.Errorf("near = %+v", near)
+	}
+
+	// A user's gazetteer can place towns the built-in one does not know.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "places.csv")
+	if err := os.WriteFile(path, []byte("country,kind,key,lat,lon\nGB,city,Witcombe,51.83,-2.14\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withDistances(listings, "GB Witcombe", 0, path); err != nil {
+		t.Errorf("home from extra gazetteer: %v", err)
+	}
+	for _, home := range []string{"4600", "AT", "XX 4600", "GB Witcombe"} {
+		if _, err := withDistances(listings, home, 0, ""); err == nil {
+			t.Errorf("home %q accepted", home)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// reportFields are the fields whose fill rate is reported after a crawl and
+// that min_fill thresholds can name.
+var reportFields = []string{
+	"title", "url", "price", "make", "model", "year", "hours", "power",
+	"condition", "dealer", "location", "country", "contacts", "image", "description",
+}
+
+// filled reports whether the named field of l has a value.
+func filled(l *Listing, field string) bool {
+	switch field {
+	case "title":
+		return l.Title != ""
+	case "url":
+		return l.URL != ""
+	case "price":
+		// The price text is kept for "price on request" too, so it shows
+		// whether the price was found at all.
+		return l.PriceText != ""
+	case "make":
+		return l.Make != ""
+	case "model":
+		return l.Model != ""
+	case "year":
+		return l.Year != 0
+	case "hours":
+		return l.Hours != 0
+	case "power":
+		return l.PowerHP != 0 || l.PowerKW != 0
+	case "condition":
+		return l.Condition != ""
+	case "dealer":
+		return l.Dealer.Name != ""
+	case "location":
+		return l.Location != ""
+	case "country":
+		return l.Place.Country != ""
+	case "contacts":
+		return len(l.Dealer.Contacts) > 0
+	case "image":
+		return l.ImageURL != ""
+	case "description":
+		return l.Description != ""
+	}
+	return false
+}
+
+// fillRate is how many of a crawl's listings have one field filled.
+type fillRate struct {
+	Field  string
+	Filled int
+	Total  int
+	Min    float64 // 0 if there is no threshold
+}
+
+func (r fillRate) rate() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Filled) / float64(r.Total)
+}
+
+// failed reports whether the rate is below its threshold.
+func (r fillRate) failed() bool {
+	return r.Total > 0 && r.rate() < r.Min
+}
+
+// fillRates counts every reportFields field over listings, applying the
+// thresholds in min.
+func fillRates(listings []Listing, min map[string]float64) []fillRate {
+	rates := make([]fillRate, len(reportFields))
+	for i, field := range reportFields {
+		rates[i] = fillRate{Field: field, Total: len(listings), Min: min[field]}
+		for j := range listings {
+			if filled(&listings[j], field) {
+				rates[i].Filled++
+			}
+		}
+	}
+	return rates
+}
+
+// fillThresholds returns the min_fill thresholds that apply to a crawl of
+// def: the listing page's, the detail page's if details were fetched, and
+// then any overrides.
+func fillThresholds(def *siteDefinition, details bool, overrides map[string]float64) map[string]float64 {
+	min := maps.Clone(def.Listing.MinFill)
+	if min == nil {
+		min = make(map[string]float64)
+	}
+	if details {
+		maps.Copy(min, def.Detail.MinFill)
+	}
+	maps.Copy(min, overrides)
+	return min
+}
+
+// parseMinFill reads the -min-fill flag: comma-separated field=rate pairs
+// such as "price=0.9,dealer=0.5".
+func parseMinFill(s string) (map[string]float64, error) {
+	min := make(map[string]float64)
+	if s == "" {
+		return min, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		rate, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid -min-fill %q: want field=rate with a rate between 0 and 1", pair)
+		}
+		if !slices.Contains(reportFields, field) {
+			return nil, fmt.Errorf("invalid -min-fill %q: unknown field %q", pair, field)
+		}
+		min[field] = rate
+	}
+	return min, nil
+}
+
+// printFillRates writes a table of rates and returns the fields below their
+// threshold.
+func printFillRates(w io.Writer, rates []fillRate) []string {
+	var failed []string
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Field fill rates:\n")
+	for _, r := range rates {
+		note := ""
+		if r.Min > 0 {
+			note = fmt.Sprintf("min %.0f%%", r.Min*100)
+		}
+		if r.failed() {
+			note += "  BELOW MINIMUM"
+			failed = append(failed, r.Field)
+		}
+		fmt.Fprintf(tw, "  %s\t%d/%d\t%.0f%%\t%s\n", r.Field, r.Filled, r.Total, r.rate()*100, note)
+	}
+	tw.Flush()
+	return failed
+}
+
+// selectorCheck is how often one selector of a site definition matched on
+// a sample page.
+type selectorCheck struct {
+	Page     string
+	Field    string
+	Selector string
+	Matches  int // items, or 1 for a detail page, in which it matched
+	Of       int
+	// Required is set for the item selectors and for fields with a
+	// min_fill threshold: it is an error if none of their selectors match.
+	Required bool
+}
+
+// checkPage counts, for every selector of p, the elements of scopes it
+// matches in.
+func checkPage(page string, p *pageDefinition, scopes *goquery.Selection) []selectorCheck {
+	var checks []selectorCheck
+	count := func(field string, selectors selectorList, required bool) {
+		for _, sel := range selectors {
+			c := selectorCheck{Page: page, Field: field, Selector: sel, Of: scopes.Length(), Required: required}
+			scopes.Each(func(i int, s *goquery.Selection) {
+				if sel == "" || s.Find(sel).Length() > 0 {
+					c.Matches++
+				}
+			})
+			checks = append(checks, c)
+		}
+	}
+
+	for _, field := range sortedKeys(p.Fields) {
+		_, required := p.MinFill[field]
+		count(field, p.Fields[field].Selector, required)
+	}
+	if t := p.Attributes; t != nil {
+		required := false
+		for _, field := range t.Fields {
+			_, ok := p.MinFill[field]
+			required = required || ok
+		}
+		count("attributes", t.Rows, required)
+	}
+	if l := p.Equipment; l != nil {
+		count("equipment", l.Items, false)
+	}
+	if c := p.Contacts; c != nil {
+		_, required := p.MinFill["contacts"]
+		count("contacts", c.Items, required)
+	}
+	return checks
+}
+
+// checkSource fetches the first results page of src and its first advert,
+// and checks every selector of the source's definition against them.
+func checkSource(ctx context.Context, src Source, f *fetcher) ([]selectorCheck, error) {
+	def := site(src.Name())
+	url := src.ListingURL(src.DefaultURL(), 1)
+	doc, err := f.fetchDocument(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []selectorCheck
+	for _, sel := range def.Listing.Item {
+		checks = append(checks, selectorCheck{Page: "listing", Field: "item", Selector: sel,
+			Matches: doc.Find(sel).Length(), Required: true})
+	}
+	checks = append(checks, checkPage("listing", &def.Listing, def.Listing.items(doc))...)
+
+	listings, _ := src.ParseListing(doc)
+	if len(listings) == 0 || listings[0].URL == "" {
+		return checks, fmt.Errorf("no advert found on %s to check the detail page with", url)
+	}
+	doc, err = f.fetchDocument(ctx, listings[0].URL)
+	if err != nil {
+		return checks, err
+	}
+	return append(checks, checkPage("detail", &def.Detail, doc.Selection)...), nil
+}
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	sourceName := fs.String("source", "", "only check this source")
+	sitesDir := fs.String("sites", "", "directory of site definitions to check instead of the built-in ones")
+	var fetchFlags fetcherFlags
+	fetchFlags.addFlags(fs)
+	fs.Parse(args)
+
+	if *sitesDir != "" {
+		if err := loadSiteDefinitions(*sitesDir); err != nil {
+			return err
+		}
+	}
+	names := sourceNames()
+	if *sourceName != "" {
+		if _, err := lookupSource(*sourceName); err != nil {
+			return err
+		}
+		names = []string{*sourceName}
+	}
+	f, err := fetchFlags.open()
+	if err != nil {
+		return err
+	}
+
+	var broken []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		checks, err := checkSource(context.Background(), sources[name], f)
+		fmt.Fprintf(w, "%s\n", name)
+		for _, c := range checks {
+			status := strconv.Itoa(c.Matches)
+			if c.Of > 0 {
+				status = fmt.Sprintf("%d/%d", c.Matches, c.Of)
+			}
+			if c.Matches == 0 {
+				status += "\tNO MATCH"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%q\t%s\n", c.Page, c.Field, c.Selector, status)
+		}
+		for _, field := range missingFields(checks) {
+			broken = append(broken, name+" "+field)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "  error: %v\n", err)
+			broken = append(broken, name)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		return fmt.Errorf("checks failed: %s", strings.Join(broken, ", "))
+	}
+	return nil
+}
+
+// missingFields returns the required page fields, as "page.field", for
+// which none of the selectors matched.
+func missingFields(checks []selectorCheck) []string {
+	matches := make(map[string]int)
+	var required []string
+	for _, c := range checks {
+		key := c.Page + "." + c.Field
+		if _, seen := matches[key]; !seen && c.Required {
+			required = append(required, key)
+		}
+		matches[key] += c.Matches
+	}
+	var missing []string
+	for _, key := range required {
+		if matches[key] == 0 {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func fixtureListings(t *testing.T, name string) []Listing {
+	t.Helper()
+	src, _ := lookupSource(name)
+	var all []Listing
+	for _, fx := range listingFixtures[name] {
+		listings, _ := src.ParseListing(loadFixture(t, filepath.Join("testdata", name, fx.file)))
+		all = append(all, listings...)
+	}
+	return all
+}
+
+func TestFillRates(t *testing.T) {
+	for _, name := range sourceNames() {
+		listings := fixtureListings(t, name)
+		min := fillThresholds(site(name), false, nil)
+		if failed := printFillRates(io.Discard, fillRates(listings, min)); len(failed) > 0 {
+			t.Errorf("%s fixtures fail the built-in thresholds for %v", name, failed)
+		}
+
+		// A redesign that loses every price, as agriaffaires once did.
+		for i := range listings {
+			listings[i].PriceText = ""
+		}
+		failed := printFillRates(io.Discard, fillRates(listings, min))
+		if !slices.Equal(failed, []string{"price"}) {
+			t.Errorf("%s without prices: failed = %v, want [price]", name, failed)
+		}
+	}
+}
+
+func TestFillThresholds(t *testing.T) {
+	def := &siteDefinition{
+		Listing: pageDefinition{MinFill: map[string]float64{"title": 0.9, "price": 0.5}},
+		Detail:  pageDefinition{MinFill: map[string]float64{"make": 0.8}},
+	}
+	got := fillThresholds(def, false, map[string]float64{"price": 0.7})
+	if len(got) != 2 || got["price"] != 0.7 || got["title"] != 0.9 {
+		t.Errorf("without details: %v", got)
+	}
+	if got := fillThresholds(def, true, nil); got["make"] != 0.8 {
+		t.Errorf("with details: %v", got)
+	}
+	if def.Listing.MinFill["price"] != 0.5 {
+		t.Error("overrides changed the site definition")
+	}
+}
+
+func TestParseMinFill(t *testing.T) {
+	got, err := parseMinFill("price=0.9, dealer=0.5")
+	if err != nil || len(got) != 2 || got["price"] != 0.9 || got["dealer"] != 0.5 {
+		t.Errorf("parseMinFill = %v, %v", got, err)
+	}
+	for _, bad := range []string{"price", "price=2", "price=x", "colour=0.5"} {
+		if _, err := parseMinFill(bad); err == nil {
+			t.Errorf("parseMinFill(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	src, _ := lookupSource("landwirt")
+	f := newFixtureFetcher(t, newFixtureSite(src))
+
+	checks, err := checkSource(context.Background(), src, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := missingFields(checks); len(missing) > 0 {
+		t.Errorf("built-in definition is missing %v", missing)
+	}
+
+	builtin := siteDefinitions["landwirt"]
+	t.Cleanup(func() { siteDefinitions["landwirt"] = builtin })
+	def := *builtin
+	def.Listing.Fields = maps.Clone(builtin.Listing.Fields)
+	def.Listing.Fields["price"] = fieldSpec{Selector: selectorList{".preis", ".old-price"}}
+	siteDefinitions["landwirt"] = &def
+
+	checks, err = checkSource(context.Background(), src, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := missingFields(checks); !slices.Equal(missing, []string{"listing.price"}) {
+		t.Errorf("missing = %v, want [listing.price]", missing)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// insertHistory records an observation unless the listing already has one
+// as recent, as when an offline run parses cached pages a second time.
+const insertHistory = `INSERT INTO listing_history
+	(source, id, observed_at, status, price_amount, price_currency, vat_included, vat_rate, original_amount, hours)
+	SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10
+	WHERE NOT EXISTS (SELECT 1 FROM listing_history WHERE source = ?1 AND id = ?2 AND observed_at >= ?3)`
+
+// observation is one row of listing_history: what a listing looked like at
+// one scrape.
+type observation struct {
+	Source     string
+	ID         string
+	ObservedAt time.Time
+	Status     string
+	Price      Price
+	Hours      int
+}
+
+// markRemoved records as removed every active listing that an earlier crawl
+// of the same search found but the crawl started at crawlStart did not. It
+// must only be called after a crawl that covered every results page, or
+// unvisited pages would count as sold.
+func (s *store) markRemoved(source, searchURL string, crawlStart, now time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	res, err := tx.Exec(`INSERT INTO listing_history
+		(source, id, observed_at, status, price_amount, price_currency, vat_included, vat_rate, original_amount, hours)
+		SELECT source, id, ?, ?, price_amount, price_currency, vat_included, vat_rate, original_amount, hours
+		FROM listings WHERE source = ? AND search_url = ? AND status = ? AND last_seen < ?`,
+		now, statusRemoved, source, searchURL, statusActive, crawlStart.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error recording removed listings: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE listings SET status = ?, matched = 0
+		WHERE source = ? AND search_url = ? AND status = ? AND last_seen < ?`,
+		statusRemoved, source, searchURL, statusActive, crawlStart.UTC()); err != nil {
+		return 0, fmt.Errorf("error marking removed listings: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), tx.Commit()
+}
+
+// listingHistory returns the observations of one listing, oldest first.
+func (s *store) listingHistory(source, id string) ([]observation, error) {
+	return s.queryHistory(`WHERE source = ? AND id = ?`, source, id)
+}
+
+func (s *store) queryHistory(where string, args ...any) ([]observation, error) {
+	rows, err := s.db.Query(`SELECT source, id, observed_at, status,
+		price_amount, price_currency, vat_included, vat_rate, original_amount, hours
+		FROM listing_history `+where+` ORDER BY source, id, observed_at`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading listing history: %w", err)
+	}
+	defer rows.Close()
+
+	var obs []observation
+	for rows.Next() {
+		var o observation
+		if err := rows.Scan(&o.Source, &o.ID, &o.ObservedAt, &o.Status,
+			&o.Price.Amount, &o.Price.Currency, &o.Price.VATIncluded, &o.Price.VATRate, &o.Price.OriginalAmount, &o.Hours); err != nil {
+			return nil, fmt.Errorf("error reading listing history: %w", err)
+		}
+		obs = append(obs, o)
+	}
+	return obs, rows.Err()
+}
+
+// changeReport summarises what happened to the store's listings in a
+// period.
+type changeReport struct {
+	New        []Listing
+	PriceDrops []priceDrop
+	Removed    []Listing
+}
+
+type priceDrop struct {
+	Listing Listing
+	From    Price
+	To      Price
+}
+
+// changesSince compares every listing's state at since with its latest
+// observation.
+func (s *store) changesSince(since time.Time) (changeReport, error) {
+	var report changeReport
+
+	listings, err := s.queryListings(listingFilter{})
+	if err != nil {
+		return report, err
+	}
+	byKey := make(map[string]Listing, len(listings))
+	for _, l := range listings {
+		byKey[l.Source+"/"+l.ID] = l
+	}
+
+	obs, err := s.queryHistory(`WHERE observed_at >= ? OR (source, id) IN
+		(SELECT source, id FROM listing_history WHERE observed_at >= ?)`, since.UTC(), since.UTC())
+	if err != nil {
+		return report, err
+	}
+
+	for start := 0; start < len(obs); {
+		end := start + 1
+		for end < len(obs) && obs[end].Source == obs[start].Source && obs[end].ID == obs[start].ID {
+			end++
+		}
+		timeline := obs[start:end]
+		start = end
+
+		l := byKey[timeline[0].Source+"/"+timeline[0].ID]
+		latest := timeline[len(timeline)-1]
+
+		if !l.FirstSeen.Before(since) {
+			report.New = append(report.New, l)
+		}
+		if latest.Status == statusRemoved && !latest.ObservedAt.Before(since) {
+			report.Removed = append(report.Removed, l)
+			continue
+		}
+
+		// The baseline is the last price observed before the period, or
+		// the first one in it for listings that are new.
+		baseline := timeline[0]
+		for _, o := range timeline {
+			if o.ObservedAt.Before(since) {
+				baseline = o
+			}
+		}
+		if baseline.Price.Currency == latest.Price.Currency &&
+			latest.Price.Amount > 0 && latest.Price.Amount < baseline.Price.Amount {
+			report.PriceDrops = append(report.PriceDrops, priceDrop{Listing: l, From: baseline.Price, To: latest.Price})
+		}
+	}
+	return report, nil
+}
+
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDB, "listing store to read")
+	sourceName := fs.String("source", "", "source of the listing, needed if the ID exists on several sites")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tractor_scraper history [flags] <listing-id>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("a listing ID is required")
+	}
+
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	listings, err := st.queryListings(listingFilter{Source: *sourceName, ID: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	switch len(listings) {
+	case 0:
+		return fmt.Errorf("no listing with ID %s", fs.Arg(0))
+	case 1:
+	default:
+		return fmt.Errorf("listing ID %s exists on several sources; choose one with -source", fs.Arg(0))
+	}
+	l := listings[0]
+
+	obs, err := st.listingHistory(l.Source, l.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s %s)\n%s\n\n", l.Title, l.Source, l.ID, l.URL)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Observed\tStatus\tPrice\tVAT\tHours\tChange")
+	var prev *observation
+	for i := range obs {
+		o := &obs[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			o.ObservedAt.Local().Format("2006-01-02 15:04"), o.Status, formatPrice(o.Price),
+			formatVAT(o.Price), formatInt(o.Hours), describeChange(prev, o))
+		prev = o
+	}
+	return w.Flush()
+}
+
+// describeChange summarises how an observation differs from the one before.
+func describeChange(prev, o *observation) string {
+	if prev == nil {
+		return "first seen"
+	}
+	var changes []string
+	if o.Status != prev.Status {
+		changes = append(changes, o.Status)
+	}
+	if o.Price.Amount != prev.Price.Amount || o.Price.Currency != prev.Price.Currency {
+		changes = append(changes, fmt.Sprintf("price %s -> %s", formatPrice(prev.Price), formatPrice(o.Price)))
+	}
+	if o.Price.VATIncluded != prev.Price.VATIncluded || o.Price.VATRate != prev.Price.VATRate {
+		changes = append(changes, "VAT "+formatVAT(o.Price))
+	}
+	if o.Hours != prev.Hours {
+		changes = append(changes, fmt.Sprintf("hours %d -> %d", prev.Hours, o.Hours))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func runChanges(args []string) error {
+	fs := flag.NewFlagSet("changes", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDB, "listing store to read")
+	sinceFlag := fs.String("since", "7d", "start of the period: a date (2024-09-18) or an age such as 7d or 36h")
+	fs.Parse(args)
+
+	since, err := parseSince(*sinceFlag, time.Now())
+	if err != nil {
+		return err
+	}
+
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	report, err := st.changesSince(since)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Changes since %s\n", since.Local().Format("2006-01-02 15:04"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "\nPrice drops (%d)\n", len(report.PriceDrops))
+	for _, d := range report.PriceDrops {
+		fmt.Fprintf(w, "  %s %s\t%s\t%s -> %s\n", d.Listing.Source, d.Listing.ID, d.Listing.Title, formatPrice(d.From), formatPrice(d.To))
+	}
+	fmt.Fprintf(w, "\nNew listings (%d)\n", len(report.New))
+	for _, l := range report.New {
+		fmt.Fprintf(w, "  %s %s\t%s\t%s\n", l.Source, l.ID, l.Title, formatPrice(l.Price))
+	}
+	fmt.Fprintf(w, "\nRemoved listings (%d)\n", len(report.Removed))
+	for _, l := range report.Removed {
+		fmt.Fprintf(w, "  %s %s\t%s\t%s\n", l.Source, l.ID, l.Title, formatPrice(l.Price))
+	}
+	return w.Flush()
+}
+
+// parseSince accepts a date, an RFC 3339 time, or an age in days ("7d") or
+// any unit time.ParseDuration understands ("36h").
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q: want a date, an RFC 3339 time or an age such as 7d", s)
+}
+
+func formatPrice(p Price) string {
+	if p.Amount == 0 {
+		return "-"
+	}
+	return strings.TrimSpace(formatAmount(p.Amount) + " " + p.Currency)
+}
+
+func formatVAT(p Price) string {
+	switch {
+	case p.Amount == 0:
+		return ""
+	case p.VATIncluded && p.VATRate > 0:
+		return "incl. " + formatAmount(p.VATRate) + "%"
+	case p.VATIncluded:
+		return "incl."
+	}
+	return "excl."
+}
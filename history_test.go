@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 9, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"7d", now.AddDate(0, 0, -7)},
+		{"0d", now},
+		{"36h", now.Add(-36 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-09-01", time.Date(2024, 9, 1, 0, 0, 0, 0, time.Local)},
+		{"2024-09-01T08:30:00Z", time.Date(2024, 9, 1, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got, err := parseSince(tt.in, now); err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "last week", "7days", "d", "2024-13-01"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("parseSince(%q) accepted", bad)
+		}
+	}
+}
+
+// TestChangesSince stores two crawls of the same search, a week and a half
+// and two days ago, and reports the changes of the last week.
+func TestChangesSince(t *testing.T) {
+	st := testStore(t)
+	now := time.Now()
+	before, recent := now.AddDate(0, 0, -10), now.AddDate(0, 0, -2)
+	search := "https://www.landwirt.com/en/used-farm-machinery/tractors.html"
+	listing := func(id string, amount float64, seen time.Time) Listing {
+		return Listing{Source: "landwirt", ID: id, SearchURL: search, Title: "Tractor " + id,
+			Price: Price{Amount: amount, Currency: "EUR"}, ScrapedAt: seen}
+	}
+
+	if err := st.upsertListings([]Listing{
+		listing("dropped", 30000, before), listing("same", 20000, before), listing("sold", 15000, before),
+		listing("raised", 10000, before),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.upsertListings([]Listing{
+		listing("dropped", 28000, recent), listing("same", 20000, recent), listing("raised", 11000, recent),
+		listing("new", 42000, recent), listing("new", 40000, now),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.markRemoved("landwirt", search, recent, recent); err != nil || n != 1 {
+		t.Fatalf("markRemoved = %d, %v; want 1", n, err)
+	}
+
+	report, err := st.changesSince(now.AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(listings []Listing) []string {
+		var ids []string
+		for _, l := range listings {
+			ids = append(ids, l.ID)
+		}
+		return ids
+	}
+	if got := ids(report.New); !slices.Equal(got, []string{"new"}) {
+		t.Errorf("new = %v", got)
+	}
+	if got := ids(report.Removed); !slices.Equal(got, []string{"sold"}) {
+		t.Errorf("removed = %v", got)
+	}
+	// A new listing's drop counts from its first price in the period.
+	var drops []string
+	for _, d := range report.PriceDrops {
+		drops = append(drops, d.Listing.ID+" "+formatPrice(d.From)+" -> "+formatPrice(d.To))
+	}
+	if want := []string{"dropped 30000 EUR -> 28000 EUR", "new 42000 EUR -> 40000 EUR"}; !slices.Equal(drops, want) {
+		t.Errorf("price drops = %v, want %v", drops, want)
+	}
+
+	// Nothing changed in the last day but the new listing's price.
+	if report, err = st.changesSince(now.AddDate(0, 0, -1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.New) != 0 || len(report.Removed) != 0 || len(report.PriceDrops) != 1 {
+		t.Errorf("last day: %+v", report)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:89.0) Gecko/20100101 Firefox/89.0",
+}
+
+func getRandomUserAgent() string {
+	return userAgents[rand.Intn(len(userAgents))]
+}
+
+var (
+	// errBlocked means the site refused to serve the page (401, 403 or 429
+	// once retries ran out). Carrying on would only make it worse.
+	errBlocked = errors.New("blocked by site")
+	// errNotFound means the page does not exist (404 or 410), usually an
+	// advert that has been taken down.
+	errNotFound = errors.New("page not found")
+)
+
+// HTTPError is returned for a response that is not a page to parse. It
+// matches errBlocked or errNotFound with errors.Is where applicable.
+type HTTPError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *HTTPError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return errBlocked
+	case http.StatusNotFound, http.StatusGone:
+		return errNotFound
+	}
+	return nil
+}
+
+// fetcher downloads pages over one shared client so connections are kept
+// alive between requests, retrying transient failures.
+type fetcher struct {
+	client     *http.Client
+	cache      *responseCache // nil when caching is off
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// fetcherOptions configures how the fetcher connects.
+type fetcherOptions struct {
+	// CABundle is a PEM file of extra root certificates to trust on top of
+	// the system pool, e.g. for a TLS-intercepting corporate proxy.
+	CABundle string
+	// Proxy is an http://, https:// or socks5:// proxy URL. When empty the
+	// HTTP_PROXY/HTTPS_PROXY environment variables apply.
+	Proxy string
+	// Insecure disables certificate verification. Debugging only.
+	Insecure bool
+	// Cache, if set, stores responses on disk and serves them back.
+	Cache *responseCache
+}
+
+// fetcherFlags are the connection and cache flags of the commands that
+// fetch pages.
+type fetcherFlags struct {
+	opts     fetcherOptions
+	useCache bool
+	cacheDir string
+	cacheTTL time.Duration
+	offline  bool
+}
+
+func (ff *fetcherFlags) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ff.opts.CABundle, "ca-bundle", "", "PEM file of extra CA certificates to trust")
+	fs.StringVar(&ff.opts.Proxy, "proxy", "", "proxy URL (http://, https:// or socks5://); default from HTTPS_PROXY")
+	fs.BoolVar(&ff.opts.Insecure, "insecure", false, "skip TLS certificate verification (debugging only)")
+	fs.BoolVar(&ff.useCache, "cache", false, "keep fetched pages in the HTTP cache and reuse them")
+	fs.StringVar(&ff.cacheDir, "cache-dir", defaultCacheDir, "HTTP cache directory")
+	fs.DurationVar(&ff.cacheTTL, "cache-ttl", 24*time.Hour, "how long a cached page is used before revalidating it")
+	fs.BoolVar(&ff.offline, "offline", false, "parse pages from the HTTP cache only, never fetching (implies -cache)")
+}
+
+// open returns a fetcher set up as the flags say.
+func (ff *fetcherFlags) open() (*fetcher, error) {
+	opts := ff.opts
+	if ff.useCache || ff.offline {
+		cache, err := newResponseCache(ff.cacheDir, ff.cacheTTL, ff.offline)
+		if err != nil {
+			return nil, err
+		}
+		opts.Cache = cache
+	}
+	return newFetcher(opts)
+}
+
+func newFetcher(opts fetcherOptions) (*fetcher, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 8
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CABundle != "" {
+		pool, err := loadCABundle(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.Insecure {
+		log.Printf("WARNING: TLS certificate verification is disabled (-insecure); use this for debugging only")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", opts.Proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q: want http, https or socks5", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &fetcher{
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: transport,
+		},
+		cache:      opts.Cache,
+		maxRetries: 4,
+		minBackoff: 2 * time.Second,
+		maxBackoff: 2 * time.Minute,
+	}, nil
+}
+
+// loadCABundle returns the system root pool with the certificates in path
+// added.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// retryable reports whether a response status is worth asking for again.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// cached reports whether url will be served from the cache without a
+// request, so the caller need not wait its turn with the rate limiter.
+func (f *fetcher) cached(url string) bool {
+	if f.cache == nil {
+		return false
+	}
+	return f.cache.offline || f.cache.usable(f.cache.loadMeta(url), time.Now())
+}
+
+// fetchedAt returns when the page at url was fetched from the site: now,
+// unless the fetcher is offline and the page comes from the cache.
+func (f *fetcher) fetchedAt(url string) time.Time {
+	if f.cache != nil && f.cache.offline {
+		if e := f.cache.loadMeta(url); e != nil {
+			return e.FetchedAt
+		}
+	}
+	return time.Now()
+}
+
+// fetchDocument downloads url and parses it as HTML.
+func (f *fetcher) fetchDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	body, err := f.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return parseDocument(url, body)
+}
+
+// fetch downloads url. Network errors and 429/5xx responses are retried
+// with exponential backoff, honoring any Retry-After header; other non-2xx
+// responses fail at once with an *HTTPError.
+func (f *fetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, wait, err := f.try(ctx, url)
+		if err == nil {
+			return body, nil
+		}
+		if wait < 0 || attempt == f.maxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			wait = f.backoff(attempt)
+		}
+		log.Printf("Retrying %s in %s: %v", url, wait.Round(time.Second), err)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// try makes one request, or none if the cache can answer. On failure wait
+// is how long the server asked us to wait before retrying (0 if it did not
+// say), or negative if the error is not worth retrying.
+func (f *fetcher) try(ctx context.Context, url string) (body []byte, wait time.Duration, err error) {
+	var cached *cacheEntry
+	if f.cache != nil {
+		cached = f.cache.load(url)
+		if f.cache.usable(cached, time.Now()) {
+			return cached.body, 0, nil
+		}
+		if f.cache.offline {
+			return nil, -1, fmt.Errorf("error fetching page %s: %w", url, errNotCached)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Set("User-Agent", getRandomUserAgent())
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-GB,en;q=0.5")
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		wait := time.Duration(-1)
+		if transient(err) {
+			wait = 0
+		}
+		return nil, wait, fmt.Errorf("error fetching page %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		if err := f.cache.touch(cached, time.Now()); err != nil {
+			log.Printf("Error updating cache for %s: %v", url, err)
+		}
+		return cached.body, 0, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := &HTTPError{URL: url, StatusCode: resp.StatusCode}
+		if !retryable(resp.StatusCode) {
+			return nil, -1, err
+		}
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now(), f.maxBackoff), err
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching page %s: %w", url, err)
+	}
+	if f.cache != nil && resp.StatusCode == http.StatusOK {
+		if err := f.cache.store(url, resp.Header, body, time.Now()); err != nil {
+			log.Printf("Error caching %s: %v", url, err)
+		}
+	}
+	return body, 0, nil
+}
+
+// transient reports whether a failed request is worth making again: it
+// timed out or the connection was refused or dropped. Certificate and
+// proxy errors, like anything else, will only fail the same way again.
+func transient(err error) bool {
+	var (
+		certErr      *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostErr      x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		opErr        *net.OpError
+		netErr       net.Error
+	)
+	switch {
+	case errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return false
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+		return false
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+func parseDocument(rawURL string, body []byte) (*goquery.Document, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing page %s: %w", rawURL, err)
+	}
+	// Links on the page are resolved against its URL.
+	doc.Url, _ = url.Parse(rawURL)
+	return doc, nil
+}
+
+// backoff is the wait before retry attempt+1: minBackoff doubled for each
+// earlier attempt, capped at maxBackoff, with up to 50% random jitter.
+func (f *fetcher) backoff(attempt int) time.Duration {
+	d := f.minBackoff << attempt
+	if d <= 0 || d > f.maxBackoff {
+		d = f.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date, capped at limit. It returns 0 if the header is absent or
+// unreadable.
+func parseRetryAfter(header string, now time.Time, limit time.Duration) time.Duration {
+	if header == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(header); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		d = t.Sub(now)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return min(d, limit)
+}
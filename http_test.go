@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHTTPError(t *testing.T) {
+	tests := []struct {
+		status  int
+		blocked bool
+		missing bool
+	}{
+		{http.StatusUnauthorized, true, false},
+		{http.StatusForbidden, true, false},
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusNotFound, false, true},
+		{http.StatusGone, false, true},
+		{http.StatusBadRequest, false, false},
+		{http.StatusInternalServerError, false, false},
+	}
+	for _, tt := range tests {
+		err := error(&HTTPError{URL: "https://example.com/", StatusCode: tt.status})
+		if got := errors.Is(err, errBlocked); got != tt.blocked {
+			t.Errorf("errors.Is(%d, errBlocked) = %v, want %v", tt.status, got, tt.blocked)
+		}
+		if got := errors.Is(err, errNotFound); got != tt.missing {
+			t.Errorf("errors.Is(%d, errNotFound) = %v, want %v", tt.status, got, tt.missing)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"3600", time.Minute},
+		{"Fri, 01 Mar 2024 12:00:20 GMT", 20 * time.Second},
+		{"Fri, 01 Mar 2024 11:59:00 GMT", 0},
+		{"Fri, 01 Mar 2024 13:00:00 GMT", time.Minute},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now, time.Minute); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	f := &fetcher{minBackoff: 2 * time.Second, maxBackoff: 2 * time.Minute}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 2 * time.Second},
+		{1, 4 * time.Second},
+		{3, 16 * time.Second},
+		{6, 2 * time.Minute},
+		{70, 2 * time.Minute}, // the shift overflows
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := f.backoff(tt.attempt); got < tt.max/2 || got > tt.max {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.max/2, tt.max)
+				break
+			}
+		}
+	}
+}
+
+// newTestFetcher returns a fetcher that retries at once.
+func newTestFetcher(t *testing.T) *fetcher {
+	t.Helper()
+	f, err := newFetcher(fetcherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.minBackoff = time.Millisecond
+	f.maxBackoff = time.Millisecond
+	return f
+}
+
+func TestFetchRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		wantStatus int // of the HTTPError returned, or 0 for success
+		wantHits   int32
+	}{
+		{"ok", []int{200}, 0, 1},
+		{"recovers", []int{503, 502, 200}, 0, 3},
+		{"rate limited", []int{429, 200}, 0, 2},
+		{"gives up", []int{500, 500, 500, 500, 500, 200}, 500, 5},
+		{"blocked", []int{429, 429, 429, 429, 429, 200}, 429, 5},
+		{"forbidden", []int{403, 200}, 403, 1},
+		{"not found", []int{404, 200}, 404, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := hits.Add(1)
+				w.WriteHeader(tt.statuses[min(int(n), len(tt.statuses))-1])
+				w.Write([]byte("page"))
+			}))
+			defer srv.Close()
+
+			body, err := newTestFetcher(t).fetch(context.Background(), srv.URL)
+			var httpErr *HTTPError
+			switch {
+			case tt.wantStatus != 0:
+				if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantStatus {
+					t.Errorf("fetch error = %v, want a %d HTTPError", err, tt.wantStatus)
+				}
+			case err != nil:
+				t.Errorf("fetch error = %v", err)
+			case string(body) != "page":
+				t.Errorf("fetch body = %q", body)
+			}
+			if got := hits.Load(); got != tt.wantHits {
+				t.Errorf("%d requests, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestFetchHonorsRetryAfter(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(t)
+	f.maxBackoff = time.Minute // parseRetryAfter caps the wait at maxBackoff
+	start := time.Now()
+	if _, err := f.fetch(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %v, want the 1s the server asked for", d)
+	}
+}
+
+func TestFetchCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(t)
+	f.maxBackoff = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := f.fetch(ctx, srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetch error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestFetcherFlags(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		args        []string
+		wantCache   bool
+		wantOffline bool
+	}{
+		{nil, false, false},
+		{[]string{"-cache", "-cache-dir", dir}, true, false},
+		{[]string{"-offline", "-cache-dir", dir}, true, true},
+		{[]string{"-proxy", "socks5://127.0.0.1:1080", "-insecure"}, false, false},
+	}
+	for _, tt := range tests {
+		var ff fetcherFlags
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		ff.addFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		f, err := ff.open()
+		if err != nil {
+			t.Errorf("open(%q) error = %v", tt.args, err)
+			continue
+		}
+		if got := f.cache != nil; got != tt.wantCache {
+			t.Errorf("open(%q) caches = %v, want %v", tt.args, got, tt.wantCache)
+		}
+		if got := f.cache != nil && f.cache.offline; got != tt.wantOffline {
+			t.Errorf("open(%q) offline = %v, want %v", tt.args, got, tt.wantOffline)
+		}
+	}
+
+	var ff fetcherFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	ff.addFlags(fs)
+	fs.Parse([]string{"-proxy", "ftp://proxy.example"})
+	if _, err := ff.open(); err == nil {
+		t.Error("open with an ftp proxy succeeded")
+	}
+}
+
+// TestFetchCertificateError checks that a server whose certificate is not
+// trusted is asked once, not retried.
+func TestFetchCertificateError(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	_, err := newTestFetcher(t).fetch(context.Background(), srv.URL)
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("fetch error = %v, want a certificate verification error", err)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("%d connections, want 1", got)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{io.ErrUnexpectedEOF, true},
+		{&net.DNSError{Err: "timeout", IsTimeout: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&net.OpError{Op: "proxyconnect", Err: syscall.ECONNREFUSED}, false},
+		{x509.UnknownAuthorityError{}, false},
+		{x509.HostnameError{}, false},
+		{errors.New("unsupported protocol scheme"), false},
+	}
+	for _, tt := range tests {
+		if got := transient(tt.err); got != tt.want {
+			t.Errorf("transient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestFetcherFlagsTLS fetches from a server with a self-signed certificate,
+// which is only trusted given as -ca-bundle, or with -insecure.
+func TestFetcherFlagsTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		wantOK bool
+	}{
+		{nil, false},
+		{[]string{"-ca-bundle", bundle}, true},
+		{[]string{"-insecure"}, true},
+	}
+	for _, tt := range tests {
+		var ff fetcherFlags
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		ff.addFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		f, err := ff.open()
+		if err != nil {
+			t.Fatalf("open(%q) error = %v", tt.args, err)
+		}
+		body, err := f.fetch(context.Background(), srv.URL)
+		if ok := err == nil && string(body) == "page"; ok != tt.wantOK {
+			t.Errorf("fetch with %q = %q, %v; want success %v", tt.args, body, err, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"strconv"
+)
+
+// imageHash is a difference hash of a picture: the picture is shrunk to 9x8
+// grey pixels and each bit says whether a pixel is brighter than its right
+// neighbour. Copies of a photo that have been resized or recompressed, as
+// they are when a dealer uploads the same one to several sites, hash to the
+// same or nearly the same value.
+func imageHash(data []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("error decoding image: %w", err)
+	}
+	const w, h = 9, 8
+	b := img.Bounds()
+	if b.Dx() < w || b.Dy() < h {
+		return "", fmt.Errorf("image too small to hash: %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Average the pixels falling into each cell of the grid.
+	var sum, n [h][w]float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * w / b.Dx()
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			n[cy][cx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if sum[y][x]/n[y][x] > sum[y][x+1]/n[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// imageDistance is the number of bits in which two image hashes differ, or
+// -1 if either is missing or invalid.
+func imageDistance(a, b string) int {
+	x, err1 := strconv.ParseUint(a, 16, 64)
+	y, err2 := strconv.ParseUint(b, 16, 64)
+	if a == "" || b == "" || err1 != nil || err2 != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
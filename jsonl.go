@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+)
+
+// exportJSONLines writes each listing as a JSON object on a line of its
+// own, in the same form as the parser goldens.
+func exportJSONLines(filename string, listings []Listing, opts exportOptions) error {
+	file, err := createExportFile(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for i := range listings {
+		if err := enc.Encode(&listings[i]); err != nil {
+			return fmt.Errorf("error writing %s listing %s: %w", listings[i].Source, listings[i].ID, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing JSON Lines file: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const landwirtOrigin = "https://www.landwirt.com"
+
+// landwirtPageSize is the number of results on each page.
+const landwirtPageSize = 20
+
+// landwirt advert URLs look like /en/used-farm-machinery,4483344,McCormick-X470.html.
+var landwirtIDRe = regexp.MustCompile(`,(\d+),[^/]*\.html`)
+
+type landwirt struct{}
+
+func init() {
+	registerSource(landwirt{})
+}
+
+func (landwirt) Name() string { return "landwirt" }
+
+func (landwirt) DefaultURL() string {
+	return landwirtOrigin + "/en/used-farm-machinery/tractors.html"
+}
+
+// Politeness allows a few parallel fetches: landwirt copes well with them.
+func (landwirt) Politeness() politeness {
+	return politeness{Workers: 4, RequestsPerSecond: 1, Burst: 2, Jitter: time.Second}
+}
+
+// ListingURL pages through results 20 at a time using the offset parameter.
+func (landwirt) ListingURL(baseURL string, page int) string {
+	return withQuery(baseURL, "offset", strconv.Itoa((page-1)*landwirtPageSize))
+}
+
+// ParseListing reads the result rows. landwirt has no reliable next link, so
+// unless the page has a rel="next" link there is assumed to be another page,
+// one offset further on, whenever this one was full. A short page is the
+// last, so the crawl ends there complete rather than on a repeated page.
+func (landwirt) ParseListing(doc *goquery.Document) ([]Listing, string) {
+	var listings []Listing
+	page := &site("landwirt").Listing
+
+	page.items(doc).Each(func(i int, s *goquery.Selection) {
+		listing := Listing{Source: "landwirt", Attributes: make(map[string]string)}
+		v := page.read(s, &listing)
+
+		listing.URL = absoluteURL(landwirtOrigin, v["url"])
+		listing.ID = idFromURL(landwirtIDRe, listing.URL)
+		listing.Place = parsePostcodeCity(listing.Location, "")
+
+		listing.PriceText = v["price"]
+		price, err := parseLandwirtPrice(v["price"], v["net_price"], v["original_price"])
+		if err != nil {
+			log.Printf("landwirt listing %s: %v", listing.ID, err)
+		}
+		listing.Price = price
+
+		listings = append(listings, listing)
+	})
+
+	next := page.nextURL(doc)
+	if next == "" && len(listings) >= landwirtPageSize && doc.Url != nil {
+		offset, _ := strconv.Atoi(doc.Url.Query().Get("offset"))
+		next = withQuery(doc.Url.String(), "offset", strconv.Itoa(offset+landwirtPageSize))
+	}
+	return listings, next
+}
+
+// parseLandwirtPrice combines the headline price with the net price line
+// ("97.420 excl. VAT 20%") and the struck-through price of a reduced advert.
+// The headline is the gross price whenever a net price is shown beside it.
+func parseLandwirtPrice(headline, net, original string) (Price, error) {
+	price, err := parsePrice(headline)
+	if err != nil {
+		return Price{}, err
+	}
+	if n, err := parsePrice(net); err == nil && n.Amount > 0 {
+		price.VATIncluded = true
+		price.VATRate = n.VATRate
+	}
+	if o, err := parsePrice(original); err == nil {
+		price.OriginalAmount = o.Amount
+	}
+	return price, nil
+}
+
+// ParseDetail reads the specification table, equipment and dealer block
+// from an advert page. The location, read from the results page, only
+// gets its country from the dealer's address.
+func (landwirt) ParseDetail(doc *goquery.Document, listing *Listing) {
+	v := site("landwirt").Detail.read(doc.Selection, listing)
+	if v["dealer_url"] != "" {
+		listing.Dealer.URL = absoluteURL(landwirtOrigin, v["dealer_url"])
+	}
+	listing.Place = parsePostcodeCity(listing.Location, listing.Dealer.Country)
+}
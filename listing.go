@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listing is the normalized form of a single advert. Every Source maps its
+// pages into this struct so downstream code never has to know which site a
+// record came from. Numeric fields are zero when the site did not give a
+// value or it could not be read.
+type Listing struct {
+	Source    string    // name of the Source that produced the listing
+	ID        string    // the site's own advert ID, taken from the URL
+	URL       string    // advert detail page
+	SearchURL string    // listing URL the crawl started from
+	ScrapedAt time.Time // when the listing page was fetched
+	FirstSeen time.Time // set when read back from the store
+	Status    string    // statusActive or statusRemoved, set by the store
+	// MachineID groups the adverts, on any site, for the same machine, and
+	// the Canonical one stands for it in reports. Both are set by the store
+	// (see matchMachines).
+	MachineID int64
+	Canonical bool
+
+	Title     string
+	Make      string
+	Series    string // the family the model belongs to, from the model catalog
+	Model     string
+	Year      int
+	Hours     int
+	PowerHP   int
+	PowerKW   int
+	Condition string
+
+	Price     Price
+	PriceText string // price as displayed, before parsing
+	// PriceInReportingCurrency is Price.Amount converted to the currency
+	// chosen for the run, 0 if no conversion was requested or possible.
+	PriceInReportingCurrency float64
+	ReportingCurrency        string
+
+	Dealer      Dealer
+	Location    string // where the machine is, as the site gives it
+	Place       Place  // Location parsed
+	ImageURL    string
+	ImageHash   string // imageHash of the picture at ImageURL, if fetched
+	Description string
+
+	// DistanceKM is the straight-line distance from the home location given
+	// to export. PlacedBy says which kind of gazetteer entry the estimate
+	// rests on, and is empty if the listing could not be placed.
+	DistanceKM float64
+	PlacedBy   string
+
+	// Features are the specifications in comparable form, from the fields
+	// mapped to them or else from the text of the advert.
+	Features Features
+
+	// Attributes keeps the site's own key/value pairs (specification
+	// tables and the like) that have no dedicated field.
+	Attributes map[string]string
+	Equipment  []string
+}
+
+// Listing statuses tracked by the store. A listing is removed when a
+// complete crawl of its source no longer finds it, which usually means the
+// machine has been sold.
+const (
+	statusActive  = "active"
+	statusRemoved = "removed"
+)
+
+const kWPerHP = 0.7457
+
+var (
+	digitsRe = regexp.MustCompile(`\d[\d.,']*`)
+	hpRe     = regexp.MustCompile(`(?i)(\d+)\s*(?:hp|ps|cv)\b`)
+	kWRe     = regexp.MustCompile(`(?i)(\d+)\s*kw\b`)
+	yearRe   = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+)
+
+// parseInt reads the first number in s, ignoring thousands separators, so
+// "4.050 h" and "4,050" both give 4050.
+func parseInt(s string) int {
+	m := digitsRe.FindString(s)
+	if m == "" {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, m))
+	return n
+}
+
+// parseYear finds a plausible four-digit year in s.
+func parseYear(s string) int {
+	n, _ := strconv.Atoi(yearRe.FindString(s))
+	return n
+}
+
+// parsePower reads strings such as "101 hp/75 kW", "35 hp" or "75 kW" and
+// fills in whichever unit is missing.
+func parsePower(s string) (hp, kW int) {
+	if m := hpRe.FindStringSubmatch(s); m != nil {
+		hp, _ = strconv.Atoi(m[1])
+	}
+	if m := kWRe.FindStringSubmatch(s); m != nil {
+		kW, _ = strconv.Atoi(m[1])
+	}
+	if hp == 0 && kW == 0 {
+		// A bare number is horsepower on both sites.
+		hp = parseInt(s)
+	}
+	switch {
+	case kW == 0 && hp > 0:
+		kW = int(math.Round(float64(hp) * kWPerHP))
+	case hp == 0 && kW > 0:
+		hp = int(math.Round(float64(kW) / kWPerHP))
+	}
+	return hp, kW
+}
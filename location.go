@@ -0,0 +1,157 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Place is a listing's Location broken into its parts. Parts the site does
+// not give are empty.
+type Place struct {
+	Postcode string
+	City     string
+	Region   string
+	Country  string // ISO 3166-1 alpha-2, e.g. "AT"
+}
+
+var (
+	// postcodeCityRe matches "1230 Wien", "A-4600 Wels" and "AT 4600 Wels":
+	// an optional country prefix, the postcode and the rest.
+	postcodeCityRe = regexp.MustCompile(`^(?:([A-Z]{1,3})(?:\s*-\s*|\s+))?(\d{4,5})\s+(.+)$`)
+	// regionSepRe separates a town from its region: a spaced hyphen or any
+	// dash.
+	regionSepRe = regexp.MustCompile(`\s+-\s+|\s*[–—]\s*`)
+)
+
+// parsePostcodeCity reads locations written as a postcode, the town and an
+// optional region after a dash, as landwirt gives them: "1230 Wien" or
+// "7080 H Trondheim – Tromsø". The country comes from a prefix such as
+// "A-" or "AT " if there is one, and otherwise from country, which may be
+// a name or a code.
+func parsePostcodeCity(s, country string) Place {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return Place{}
+	}
+	p := Place{Country: countryCode(country)}
+	rest := s
+	if m := postcodeCityRe.FindStringSubmatch(s); m != nil {
+		if code := countryCode(m[1]); code != "" {
+			p.Country = code
+		}
+		p.Postcode, rest = m[2], m[3]
+	}
+	p.City, p.Region = splitRegion(rest)
+	if p.Region == "" && p.Country == "AT" {
+		p.Region = austrianState(p.Postcode)
+	}
+	return p
+}
+
+// parseCountryRegion reads locations written as a country and a region,
+// as agriaffaires gives them: "United Kingdom - Gloucestershire".
+func parseCountryRegion(s string) Place {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return Place{}
+	}
+	country, region := splitRegion(s)
+	if code := countryCode(country); code != "" {
+		return Place{Country: code, Region: region}
+	}
+	// Not a country: keep the text as the region rather than lose it.
+	return Place{Region: s}
+}
+
+// splitRegion splits s at its first dash.
+func splitRegion(s string) (before, after string) {
+	parts := regionSepRe.Split(s, 2)
+	if len(parts) == 1 {
+		return s, ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// countryCodes maps the country names used on the sites, in English,
+// German and French, and the international licence plate codes once
+// written before postcodes ("A-1230"), to ISO codes.
+var countryCodes = map[string]string{
+	"austria": "AT", "österreich": "AT", "autriche": "AT", "a": "AT",
+	"belgium": "BE", "belgien": "BE", "belgique": "BE", "b": "BE",
+	"bulgaria": "BG", "bulgarien": "BG", "bulgarie": "BG",
+	"croatia": "HR", "kroatien": "HR", "croatie": "HR",
+	"czech republic": "CZ", "czechia": "CZ", "tschechien": "CZ", "république tchèque": "CZ",
+	"denmark": "DK", "dänemark": "DK", "danemark": "DK", "dk": "DK",
+	"estonia": "EE", "estland": "EE", "estonie": "EE",
+	"finland": "FI", "finnland": "FI", "finlande": "FI",
+	"france": "FR", "frankreich": "FR", "f": "FR",
+	"germany": "DE", "deutschland": "DE", "allemagne": "DE", "d": "DE",
+	"greece": "GR", "griechenland": "GR", "grèce": "GR",
+	"hungary": "HU", "ungarn": "HU", "hongrie": "HU", "h": "HU",
+	"ireland": "IE", "irland": "IE", "irlande": "IE", "irl": "IE",
+	"italy": "IT", "italien": "IT", "italie": "IT", "i": "IT",
+	"latvia": "LV", "lettland": "LV", "lettonie": "LV",
+	"lithuania": "LT", "litauen": "LT", "lituanie": "LT",
+	"luxembourg": "LU", "luxemburg": "LU", "l": "LU",
+	"netherlands": "NL", "the netherlands": "NL", "niederlande": "NL", "pays-bas": "NL",
+	"norway": "NO", "norwegen": "NO", "norvège": "NO", "n": "NO",
+	"poland": "PL", "polen": "PL", "pologne": "PL",
+	"portugal": "PT",
+	"romania":  "RO", "rumänien": "RO", "roumanie": "RO",
+	"serbia": "RS", "serbien": "RS", "serbie": "RS",
+	"slovakia": "SK", "slowakei": "SK", "slovaquie": "SK",
+	"slovenia": "SI", "slowenien": "SI", "slovénie": "SI", "slo": "SI",
+	"spain": "ES", "spanien": "ES", "espagne": "ES", "e": "ES",
+	"sweden": "SE", "schweden": "SE", "suède": "SE", "s": "SE",
+	"switzerland": "CH", "schweiz": "CH", "suisse": "CH",
+	"ukraine":        "UA",
+	"united kingdom": "GB", "uk": "GB", "great britain": "GB", "england": "GB",
+	"scotland": "GB", "wales": "GB", "northern ireland": "GB", "royaume-uni": "GB",
+	"großbritannien": "GB", "vereinigtes königreich": "GB",
+}
+
+// countryCode returns the ISO code for a country name, plate code or ISO
+// code, or "" if it is none of these.
+func countryCode(s string) string {
+	s = strings.TrimSpace(s)
+	if code, ok := countryCodes[strings.ToLower(s)]; ok {
+		return code
+	}
+	upper := strings.ToUpper(s)
+	for _, code := range countryCodes {
+		if code == upper {
+			return code
+		}
+	}
+	return ""
+}
+
+// austrianState returns the federal state an Austrian postcode belongs to.
+// The first digits follow the postal regions, which mostly coincide with
+// the states.
+func austrianState(postcode string) string {
+	if len(postcode) != 4 {
+		return ""
+	}
+	switch {
+	case postcode[0] == '1':
+		return "Wien"
+	case postcode[0] == '2' || postcode[0] == '3':
+		return "Niederösterreich"
+	case postcode[0] == '4':
+		return "Oberösterreich"
+	case postcode[0] == '5':
+		return "Salzburg"
+	case postcode[:2] >= "67" && postcode[:2] <= "69":
+		return "Vorarlberg"
+	case postcode[0] == '6' || postcode[:2] == "99":
+		return "Tirol"
+	case postcode[0] == '7':
+		return "Burgenland"
+	case postcode[0] == '8':
+		return "Steiermark"
+	case postcode[0] == '9':
+		return "Kärnten"
+	}
+	return ""
+}
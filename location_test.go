@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParsePostcodeCity(t *testing.T) {
+	tests := []struct {
+		in, country string
+		want        Place
+	}{
+		{"1230 Wien", "AT", Place{Postcode: "1230", City: "Wien", Region: "Wien", Country: "AT"}},
+		{"7080 H Trondheim – Tromsø", "", Place{Postcode: "7080", City: "H Trondheim", Region: "Tromsø"}},
+		{"A-6850 Dornbirn", "", Place{Postcode: "6850", City: "Dornbirn", Region: "Vorarlberg", Country: "AT"}},
+		{"DE 84034 Landshut", "Austria", Place{Postcode: "84034", City: "Landshut", Country: "DE"}},
+		{"Bad Hall-Land", "", Place{City: "Bad Hall-Land"}},
+		{"  ", "AT", Place{}},
+	}
+	for _, tt := range tests {
+		if got := parsePostcodeCity(tt.in, tt.country); got != tt.want {
+			t.Errorf("parsePostcodeCity(%q, %q) = %+v, want %+v", tt.in, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestParseCountryRegion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Place
+	}{
+		{"United Kingdom - Gloucestershire", Place{Region: "Gloucestershire", Country: "GB"}},
+		{"France - Pays de la Loire - Vendée", Place{Region: "Pays de la Loire - Vendée", Country: "FR"}},
+		{"Ireland", Place{Country: "IE"}},
+		{"Somewhere - Else", Place{Region: "Somewhere - Else"}},
+	}
+	for _, tt := range tests {
+		if got := parseCountryRegion(tt.in); got != tt.want {
+			t.Errorf("parseCountryRegion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCountryCode(t *testing.T) {
+	for in, want := range map[string]string{
+		"Austria": "AT", "ÖSTERREICH": "AT", "at": "AT", "A": "AT",
+		"United Kingdom": "GB", "GB": "GB", "Atlantis": "", "": "",
+	} {
+		if got := countryCode(in); got != want {
+			t.Errorf("countryCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Limits within which two adverts are taken to agree.
+const (
+	// maxImageDistance is the number of bits two picture hashes may differ
+	// by: recompression flips a few, a different photo about half.
+	maxImageDistance = 6
+	// hoursTolerance allows for adverts on different sites being updated at
+	// different times, with at least minHoursTolerance hours of slack.
+	hoursTolerance    = 0.03
+	minHoursTolerance = 25
+	// Net prices within priceTolerance agree; beyond priceConflict they
+	// are taken to be different machines. Dealers sometimes ask a little
+	// more on one site than another.
+	priceTolerance = 0.05
+	priceConflict  = 0.2
+)
+
+// agreement is how a field of two adverts compares.
+type agreement int
+
+const (
+	unknown     agreement = iota // one or both adverts lack the field
+	agree                        // the values match
+	contradicts                  // the values cannot be the same machine
+)
+
+// sameMachine reports whether a and b look like adverts for the same
+// machine. A matching picture is enough if the model, year or hours agree
+// with it and neither the make nor any of those contradicts it: dealers
+// reuse stock photos and placeholders. Otherwise the makes must agree,
+// none of model, year, hours, dealer and price may contradict, and at
+// least three of them must agree, among them the hours or the dealer: two
+// dealers can well have the same model of the same year at the same price.
+func sameMachine(a, b *Listing) bool {
+	if a.Source == b.Source && listingKey(*a) == listingKey(*b) {
+		return false
+	}
+	makes, years := compareText(a.Make, b.Make, false), compareInt(a.Year, b.Year, 0)
+	if makes == contradicts || years == contradicts {
+		return false
+	}
+	models, hours := compareText(a.Model, b.Model, true), compareHours(a.Hours, b.Hours)
+	if d := imageDistance(a.ImageHash, b.ImageHash); d >= 0 && d <= maxImageDistance {
+		pictured := []agreement{models, years, hours}
+		if slices.Contains(pictured, agree) && !slices.Contains(pictured, contradicts) {
+			return true
+		}
+	}
+	if makes != agree {
+		return false
+	}
+
+	dealers := compareDealers(a.Dealer, b.Dealer)
+	fields := []agreement{models, years, hours, dealers, comparePrices(a, b)}
+	if slices.Contains(fields, contradicts) {
+		return false
+	}
+	agreeing := 0
+	for _, f := range fields {
+		if f == agree {
+			agreeing++
+		}
+	}
+	return agreeing >= 3 && (hours == agree || dealers == agree)
+}
+
+// compareText compares names ignoring case, spaces and punctuation, so
+// "TTX 190" matches "TTX-190". With partial, one may contain the other, as
+// "Major" does "Major Diesel".
+func compareText(a, b string, partial bool) agreement {
+	a, b = slug(a), slug(b)
+	a, b = strings.ReplaceAll(a, "-", ""), strings.ReplaceAll(b, "-", "")
+	switch {
+	case a == "" || b == "":
+		return unknown
+	case a == b, partial && (strings.Contains(a, b) || strings.Contains(b, a)):
+		return agree
+	}
+	return contradicts
+}
+
+func compareInt(a, b, tolerance int) agreement {
+	switch {
+	case a == 0 || b == 0:
+		return unknown
+	case a-b <= tolerance && b-a <= tolerance:
+		return agree
+	}
+	return contradicts
+}
+
+func compareHours(a, b int) agreement {
+	tolerance := int(hoursTolerance * float64(max(a, b)))
+	return compareInt(a, b, max(tolerance, minHoursTolerance))
+}
+
+// compareDealers matches dealers by postcode or by most of the words of
+// their names, since each site spells them its own way. Dealers in
+// different countries contradict.
+func compareDealers(a, b Dealer) agreement {
+	if a.Country != "" && b.Country != "" && a.Country != b.Country {
+		return contradicts
+	}
+	if a.Postcode != "" && slug(a.Postcode) == slug(b.Postcode) {
+		return agree
+	}
+	wa, wb := strings.Split(slug(a.Name), "-"), strings.Split(slug(b.Name), "-")
+	if wa[0] == "" || wb[0] == "" {
+		return unknown
+	}
+	shared := 0
+	for _, w := range wa {
+		if slices.Contains(wb, w) {
+			shared++
+		}
+	}
+	if 2*shared >= min(len(wa), len(wb)) && shared > 0 {
+		return agree
+	}
+	return unknown
+}
+
+// comparePrices compares the net asking prices, in the listings' own
+// currency or, failing that, the reporting currency.
+func comparePrices(a, b *Listing) agreement {
+	var x, y float64
+	switch {
+	case a.Price.Currency != "" && a.Price.Currency == b.Price.Currency:
+		x, y = netAmount(a.Price.Amount, a.Price), netAmount(b.Price.Amount, b.Price)
+	case a.ReportingCurrency != "" && a.ReportingCurrency == b.ReportingCurrency:
+		x, y = netAmount(a.PriceInReportingCurrency, a.Price), netAmount(b.PriceInReportingCurrency, b.Price)
+	}
+	if x == 0 || y == 0 {
+		return unknown
+	}
+	switch diff := math.Abs(x-y) / math.Max(x, y); {
+	case diff <= priceTolerance:
+		return agree
+	case diff > priceConflict:
+		return contradicts
+	}
+	return unknown
+}
+
+// netAmount removes the VAT from amount if p says it includes it.
+func netAmount(amount float64, p Price) float64 {
+	if p.VATIncluded && p.VATRate > 0 {
+		return amount / (1 + p.VATRate/100)
+	}
+	return amount
+}
+
+// clusterMachines groups the adverts for the same machine, whether they
+// match directly or through another advert, and returns for each listing
+// the index of the first listing in its group. Only the pending adverts are
+// compared with the others: with those of the same make and, by their
+// picture, with those without a make, or, lacking a make themselves, with
+// every advert with a picture. The other adverts keep the machine they are
+// stored with, unless a pending advert belongs to it, when its adverts are
+// compared with each other again.
+func clusterMachines(listings []Listing, pending []bool) []int {
+	group := make([]int, len(listings))
+	for i := range group {
+		group[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if group[i] != i {
+			group[i] = find(group[i])
+		}
+		return group[i]
+	}
+	link := func(i, j int) {
+		a, b := find(i), find(j)
+		group[max(a, b)] = min(a, b)
+	}
+	union := func(i, j int) {
+		if sameMachine(&listings[i], &listings[j]) {
+			link(i, j)
+		}
+	}
+
+	regroup := make(map[int64]bool)
+	for i, l := range listings {
+		if pending[i] && l.MachineID > 0 {
+			regroup[l.MachineID] = true
+		}
+	}
+	var (
+		first   = make(map[int64]int)
+		members = make(map[int64][]int)
+		byMake  = make(map[string][]int)
+		// pictured and unnamed hold the adverts with a picture, and those
+		// of them without a make.
+		pictured, unnamed []int
+	)
+	for i, l := range listings {
+		switch id := l.MachineID; {
+		case id == 0:
+		case regroup[id]:
+			members[id] = append(members[id], i)
+		case !pending[i]:
+			if j, ok := first[id]; ok {
+				link(i, j)
+			} else {
+				first[id] = i
+			}
+		}
+		key := slug(l.Make)
+		byMake[key] = append(byMake[key], i)
+		if l.ImageHash != "" {
+			pictured = append(pictured, i)
+			if key == "" {
+				unnamed = append(unnamed, i)
+			}
+		}
+	}
+	for _, idx := range members {
+		for n, i := range idx {
+			for _, j := range idx[n+1:] {
+				union(i, j)
+			}
+		}
+	}
+
+	for i, l := range listings {
+		if !pending[i] {
+			continue
+		}
+		var candidates []int
+		switch key := slug(l.Make); {
+		case key != "":
+			candidates = byMake[key]
+			if l.ImageHash != "" {
+				candidates = append(slices.Clip(candidates), unnamed...)
+			}
+		case l.ImageHash != "":
+			candidates = pictured
+		}
+		for _, j := range candidates {
+			// Two pending adverts are compared once.
+			if j != i && !(pending[j] && j < i) {
+				union(i, j)
+			}
+		}
+	}
+	for i := range group {
+		group[i] = find(i)
+	}
+	return group
+}
+
+// canonicalOrder sorts the adverts of a machine with the one reports
+// should count first: an active advert before a removed one, then the one
+// with the most detail, then the oldest.
+func canonicalOrder(a, b Listing) int {
+	detail := func(l Listing) int {
+		n := len(l.Attributes) + len(l.Equipment)
+		if l.Description != "" {
+			n++
+		}
+		return n
+	}
+	return cmp.Or(
+		cmp.Compare(statusRank(a.Status), statusRank(b.Status)),
+		cmp.Compare(detail(b), detail(a)),
+		a.FirstSeen.Compare(b.FirstSeen),
+		cmp.Compare(a.Source, b.Source),
+		cmp.Compare(a.ID, b.ID),
+	)
+}
+
+func statusRank(status string) int {
+	if status == statusRemoved {
+		return 1
+	}
+	return 0
+}
+
+// matchMachines groups into machines the listings stored or changed since
+// it last ran, and records each listing's machine_id and whether it is the
+// canonical advert of its machine. They are compared only with the stored
+// listings that could be the same machine (see clusterMachines). A machine
+// keeps the lowest ID any of its adverts had, so IDs stay stable as adverts
+// come and go; new machines are numbered after the highest. It returns the
+// number of machines with more than one advert.
+func (s *store) matchMachines() (int, error) {
+	listings, pending, err := s.machineCandidates()
+	if err != nil {
+		return 0, err
+	}
+	members := make(map[int][]int)
+	for i, g := range clusterMachines(listings, pending) {
+		members[g] = append(members[g], i)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var next int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(machine_id), 0) FROM listings").Scan(&next); err != nil {
+		return 0, fmt.Errorf("error reading machine IDs: %w", err)
+	}
+	taken := make(map[int64]bool)
+
+	stmt, err := tx.Prepare("UPDATE listings SET machine_id = ?, canonical = ?, matched = 1 WHERE source = ? AND id = ?")
+	if err != nil {
+		return 0, fmt.Errorf("error preparing machine update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, g := range slices.Sorted(maps.Keys(members)) {
+		idx := members[g]
+		var id int64
+		for _, i := range idx {
+			if m := listings[i].MachineID; m > 0 && !taken[m] && (id == 0 || m < id) {
+				id = m
+			}
+		}
+		if id == 0 {
+			next++
+			id = next
+		}
+		taken[id] = true
+		canonical := slices.MinFunc(idx, func(i, j int) int { return canonicalOrder(listings[i], listings[j]) })
+		for _, i := range idx {
+			l := listings[i]
+			if !pending[i] && l.MachineID == id && l.Canonical == (i == canonical) {
+				continue
+			}
+			if _, err := stmt.Exec(id, i == canonical, l.Source, l.ID); err != nil {
+				return 0, fmt.Errorf("error storing machine of %s listing %s: %w", l.Source, l.ID, err)
+			}
+		}
+	}
+
+	var shared int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM (SELECT machine_id FROM listings
+		WHERE machine_id > 0 GROUP BY machine_id HAVING COUNT(*) > 1)`).Scan(&shared)
+	if err != nil {
+		return 0, fmt.Errorf("error counting machines: %w", err)
+	}
+	return shared, tx.Commit()
+}
+
+// machineCandidates returns the listings matchMachines has yet to match,
+// flagged as pending, followed by those they could be the same machine as:
+// the listings of the same makes and, if any has a picture, those with a
+// picture and no make, or every listing with a picture for one without a
+// make. Every machine among them comes with all its adverts.
+func (s *store) machineCandidates() ([]Listing, []bool, error) {
+	listings, err := s.listingsWhere("matched = 0", nil, "")
+	if err != nil || len(listings) == 0 {
+		return nil, nil, err
+	}
+	pending := make([]bool, len(listings))
+	for i := range pending {
+		pending[i] = true
+	}
+
+	makes := make(map[string]bool)
+	var pictured, unnamed bool
+	for _, l := range listings {
+		if key := slug(l.Make); key != "" {
+			makes[key] = true
+		} else if l.ImageHash != "" {
+			unnamed = true
+		}
+		pictured = pictured || l.ImageHash != ""
+	}
+	// Makes are compared by slug, so every spelling stored is looked up.
+	stored, err := s.distinctMakes()
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		alternatives []string
+		args         []any
+	)
+	if spellings := slices.DeleteFunc(stored, func(m string) bool { return !makes[slug(m)] }); len(spellings) > 0 {
+		alternatives = append(alternatives, "make IN ("+placeholders(len(spellings))+")")
+		for _, m := range spellings {
+			args = append(args, m)
+		}
+	}
+	switch {
+	case unnamed:
+		alternatives = append(alternatives, "image_hash != ''")
+	case pictured:
+		alternatives = append(alternatives, "(make = '' AND image_hash != '')")
+	}
+	if len(alternatives) > 0 {
+		others, err := s.listingsWhere("matched = 1 AND ("+strings.Join(alternatives, " OR ")+")", args, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		listings = append(listings, others...)
+	}
+
+	// The rest of the adverts of their machines, which may be regrouped.
+	loaded := make(map[[2]string]bool)
+	ids := make(map[int64]bool)
+	for _, l := range listings {
+		loaded[[2]string{l.Source, l.ID}] = true
+		if l.MachineID > 0 {
+			ids[l.MachineID] = true
+		}
+	}
+	if len(ids) > 0 {
+		args = args[:0]
+		for _, id := range slices.Sorted(maps.Keys(ids)) {
+			args = append(args, id)
+		}
+		more, err := s.listingsWhere("machine_id IN ("+placeholders(len(args))+")", args, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, l := range more {
+			if !loaded[[2]string{l.Source, l.ID}] {
+				listings = append(listings, l)
+			}
+		}
+	}
+	return listings, append(pending, make([]bool, len(listings)-len(pending))...), nil
+}
+
+// distinctMakes returns every make stored, as spelled.
+func (s *store) distinctMakes() ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT make FROM listings WHERE make != ''")
+	if err != nil {
+		return nil, fmt.Errorf("error reading makes: %w", err)
+	}
+	defer rows.Close()
+	var makes []string
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return nil, err
+		}
+		makes = append(makes, m)
+	}
+	return makes, rows.Err()
+}
+
+// placeholders returns n comma-separated SQL parameters.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// machineAdverts returns the stored listings grouped by machine, as the
+// last matchMachines left them, canonical advert first, for the machines
+// with an advert from source (or any source if it is ""). Listings not yet
+// matched are machines of their own. Unless all is set, only machines with
+// several adverts are returned.
+func (s *store) machineAdverts(source string, all bool) ([][]Listing, error) {
+	listings, err := s.queryListings(listingFilter{})
+	if err != nil {
+		return nil, err
+	}
+	byMachine := make(map[int64][]Listing)
+	for i, l := range listings {
+		id := l.MachineID
+		if id == 0 {
+			id = -int64(i) - 1
+		}
+		byMachine[id] = append(byMachine[id], l)
+	}
+	var machines [][]Listing
+	for _, adverts := range byMachine {
+		if !all && len(adverts) < 2 ||
+			source != "" && !slices.ContainsFunc(adverts, func(l Listing) bool { return l.Source == source }) {
+			continue
+		}
+		slices.SortFunc(adverts, func(a, b Listing) int {
+			return cmp.Or(-cmp.Compare(boolRank(a.Canonical), boolRank(b.Canonical)), canonicalOrder(a, b))
+		})
+		machines = append(machines, adverts)
+	}
+	// Unmatched listings all have MachineID 0.
+	slices.SortFunc(machines, func(a, b []Listing) int {
+		return cmp.Or(cmp.Compare(a[0].MachineID, b[0].MachineID),
+			cmp.Compare(a[0].Source, b[0].Source), cmp.Compare(listingKey(a[0]), listingKey(b[0])))
+	})
+	return machines, nil
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func runMachines(args []string) error {
+	fs := flag.NewFlagSet("machines", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDB, "listing store to read")
+	sourceName := fs.String("source", "", "only list machines advertised on this source")
+	all := fs.Bool("all", false, "also list machines with a single advert")
+	fs.Parse(args)
+
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	machines, err := st.machineAdverts(*sourceName, *all)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Machine\tMake\tModel\tYear\tHours\t\tSource\tID\tPrice\tStatus\tDealer\tURL")
+	for _, adverts := range machines {
+		for i, l := range adverts {
+			head := "\t\t\t\t"
+			if i == 0 {
+				head = fmt.Sprintf("%d\t%s\t%s\t%s\t%s", l.MachineID, l.Make, l.Model, formatInt(l.Year), formatInt(l.Hours))
+			}
+			mark := ""
+			if l.Canonical {
+				mark = "*"
+			}
+			price := strings.TrimSpace(formatAmount(l.Price.Amount) + " " + l.Price.Currency)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", head, mark, l.Source, l.ID, price, l.Status, l.Dealer.Name, l.URL)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d machines; * marks the advert counted in reports\n", len(machines))
+	return nil
+}
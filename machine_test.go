@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// majorAt is a Fordson Major advertised by an Austrian dealer on landwirt.
+func majorAt() Listing {
+	return Listing{
+		Source: "landwirt", ID: "4491022", Make: "Fordson", Model: "Major", Year: 1958, Hours: 4050,
+		Dealer: Dealer{Name: "Lagerhaus Technik-Center", Postcode: "4600", Country: "AT"},
+		Price:  Price{Amount: 12000, Currency: "EUR", VATIncluded: true, VATRate: 20},
+	}
+}
+
+func TestSameMachine(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(l *Listing)
+		want   bool
+	}{
+		{"same advert on agriaffaires", func(l *Listing) {}, true},
+		{"hours far apart", func(l *Listing) { l.Hours = 6000 }, false},
+		{"different year", func(l *Listing) { l.Year = 1961 }, false},
+		{"different dealer country", func(l *Listing) { l.Dealer = Dealer{Name: "Lagerhaus", Country: "DE"} }, false},
+		{"price far apart", func(l *Listing) { l.Price.Amount = 15000 }, false},
+		{"only model, year and price", func(l *Listing) { l.Hours, l.Dealer = 0, Dealer{Name: "Someone Else"} }, false},
+		{"same picture, nothing else known", func(l *Listing) { *l = Listing{Source: "agriaffaires", ID: "1", ImageHash: "0f0f0f0f0f0f0f0f"} }, false},
+		{"same picture and year", func(l *Listing) {
+			*l = Listing{Source: "agriaffaires", ID: "1", Year: 1958, ImageHash: "0f0f0f0f0f0f0f0f"}
+		}, true},
+		{"same picture, other model", func(l *Listing) {
+			*l = Listing{Source: "agriaffaires", ID: "1", Model: "Dexta", Year: 1958, ImageHash: "0f0f0f0f0f0f0f0f"}
+		}, false},
+		{"same picture, different year", func(l *Listing) { l.Year, l.ImageHash = 1961, "0f0f0f0f0f0f0f0f" }, false},
+	}
+	a := majorAt()
+	a.ImageHash = "0f0f0f0f0f0f0f0e"
+	for _, tt := range tests {
+		b := Listing{
+			Source: "agriaffaires", ID: "44698339", Make: "FORDSON", Model: "Major Diesel", Year: 1958, Hours: 4100,
+			Dealer: Dealer{Name: "Lagerhaus Wels", Country: "AT"},
+			Price:  Price{Amount: 10000, Currency: "EUR"},
+		}
+		tt.change(&b)
+		if got := sameMachine(&a, &b); got != tt.want {
+			t.Errorf("%s: sameMachine = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testImage draws a picture with some structure to hash, w by h pixels.
+func testImage(t *testing.T, w, h int, invert bool) []byte {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*255/w + (y*7/h)*30) % 256)
+			if (x*4/w+y*3/h)%2 == 0 {
+				v /= 2
+			}
+			if invert {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageHash(t *testing.T) {
+	hash := func(data []byte) string {
+		h, err := imageHash(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	large, small, other := hash(testImage(t, 360, 240, false)), hash(testImage(t, 90, 60, false)), hash(testImage(t, 360, 240, true))
+	if d := imageDistance(large, small); d < 0 || d > maxImageDistance {
+		t.Errorf("resized copy differs by %d bits", d)
+	}
+	if d := imageDistance(large, other); d <= maxImageDistance {
+		t.Errorf("different picture differs by only %d bits", d)
+	}
+	if _, err := imageHash([]byte("not an image")); err == nil {
+		t.Error("no error for data that is not an image")
+	}
+}
+
+// TestMatchMachines stores the same machine advertised on two sites and
+// checks that the machine keeps its ID as other adverts arrive.
+func TestMatchMachines(t *testing.T) {
+	st, err := openStore(filepath.Join(t.TempDir(), "tractors.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	now := time.Now()
+	at := majorAt()
+	at.ScrapedAt = now
+	gb := Listing{
+		Source: "agriaffaires", ID: "44698339", Make: "Fordson", Model: "Major", Year: 1958, Hours: 4060,
+		Dealer:      Dealer{Name: "Lagerhaus Technik Center", Postcode: "4600", Country: "AT"},
+		Price:       Price{Amount: 10000, Currency: "EUR"},
+		Description: "Restored.", ScrapedAt: now,
+	}
+	other := Listing{Source: "landwirt", ID: "4470195", Make: "McCormick", Model: "TTX 190", Year: 2009, ScrapedAt: now}
+	if err := st.upsertListings([]Listing{at, gb, other}); err != nil {
+		t.Fatal(err)
+	}
+	if shared, err := st.matchMachines(); err != nil || shared != 1 {
+		t.Fatalf("matchMachines = %d, %v; want 1 machine with several adverts", shared, err)
+	}
+
+	machines, err := st.machineAdverts("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 2 || len(machines[0]) != 2 || len(machines[1]) != 1 {
+		t.Fatalf("got machines %+v", machines)
+	}
+	// The agriaffaires advert has a description, so it is the canonical one.
+	first := machines[0]
+	if !first[0].Canonical || first[0].Source != "agriaffaires" || first[1].Canonical ||
+		first[0].MachineID != first[1].MachineID {
+		t.Errorf("Fordson adverts: %+v", first)
+	}
+	if !machines[1][0].Canonical {
+		t.Error("single advert is not canonical")
+	}
+
+	deutz := Listing{Source: "landwirt", ID: "1", Make: "Deutz", Year: 1970, ScrapedAt: now}
+	if err := st.upsertListings([]Listing{deutz}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.matchMachines(); err != nil {
+		t.Fatal(err)
+	}
+	rematched, err := st.machineAdverts("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rematched) != 3 || rematched[0][0].MachineID != first[0].MachineID || rematched[2][0].ID != "1" {
+		t.Errorf("machine IDs changed: %+v", rematched)
+	}
+}
+
+// TestMachineAdvertsUnmatched lists listings not yet matched, which share
+// MachineID 0, in a fixed order.
+func TestMachineAdvertsUnmatched(t *testing.T) {
+	st := testStore(t)
+	now := time.Now()
+	var listings []Listing
+	for _, key := range []string{"landwirt/2", "agriaffaires/9", "landwirt/1"} {
+		source, id, _ := strings.Cut(key, "/")
+		listings = append(listings, Listing{Source: source, ID: id, ScrapedAt: now})
+	}
+	if err := st.upsertListings(listings); err != nil {
+		t.Fatal(err)
+	}
+	machines, err := st.machineAdverts("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range machines {
+		got = append(got, m[0].Source+"/"+m[0].ID)
+	}
+	if want := []string{"agriaffaires/9", "landwirt/1", "landwirt/2"}; !slices.Equal(got, want) {
+		t.Errorf("machines %v, want %v", got, want)
+	}
+}
+
+// TestMatchMachinesIncremental checks that only listings stored or changed
+// since the last match are matched again, and that a change can split a
+// machine or move its canonical advert.
+func TestMatchMachinesIncremental(t *testing.T) {
+	st := testStore(t)
+	now := time.Now()
+	at := majorAt()
+	at.ScrapedAt = now
+	gb := Listing{
+		Source: "agriaffaires", ID: "44698339", Make: "fordson", Model: "Major", Year: 1958, Hours: 4060,
+		Dealer:      Dealer{Name: "Lagerhaus Technik Center", Postcode: "4600", Country: "AT"},
+		Price:       Price{Amount: 10000, Currency: "EUR"},
+		Description: "Restored.", ScrapedAt: now,
+	}
+	if err := st.upsertListings([]Listing{at, gb}); err != nil {
+		t.Fatal(err)
+	}
+	unmatched := func() int {
+		t.Helper()
+		var n int
+		if err := st.db.QueryRow("SELECT COUNT(*) FROM listings WHERE matched = 0").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	// The makes are spelled differently but compared by slug.
+	if shared, err := st.matchMachines(); err != nil || shared != 1 || unmatched() != 0 {
+		t.Fatalf("matchMachines = %d, %v with %d unmatched; want 1, none", shared, err, unmatched())
+	}
+	id := storedListing(t, st, "landwirt", at.ID).MachineID
+
+	// Seen again unchanged, or without the details, it needs no match.
+	at.ScrapedAt = now.Add(time.Minute)
+	bare := at
+	bare.Make, bare.ScrapedAt = "", now.Add(time.Minute)
+	if err := st.upsertListings([]Listing{bare}); err != nil {
+		t.Fatal(err)
+	}
+	if n := unmatched(); n != 0 {
+		t.Errorf("%d listings to match after an unchanged upsert, want 0", n)
+	}
+
+	// Hours far apart split the machine: one advert keeps its ID.
+	gb.Hours, gb.ScrapedAt = 6000, now.Add(2*time.Minute)
+	if err := st.upsertListings([]Listing{gb}); err != nil {
+		t.Fatal(err)
+	}
+	if n := unmatched(); n != 1 {
+		t.Errorf("%d listings to match after changing the hours, want 1", n)
+	}
+	if shared, err := st.matchMachines(); err != nil || shared != 0 {
+		t.Fatalf("matchMachines after the split = %d, %v; want 0", shared, err)
+	}
+	gotAt, gotGB := storedListing(t, st, "landwirt", at.ID), storedListing(t, st, "agriaffaires", gb.ID)
+	if gotAt.MachineID == gotGB.MachineID || (gotAt.MachineID != id && gotGB.MachineID != id) ||
+		!gotAt.Canonical || !gotGB.Canonical {
+		t.Errorf("after the split: landwirt machine %d (canonical %v), agriaffaires %d (canonical %v); want %d and another",
+			gotAt.MachineID, gotAt.Canonical, gotGB.MachineID, gotGB.Canonical, id)
+	}
+
+	// Joined again, the agriaffaires advert with its description is
+	// canonical until it is removed.
+	gb.Hours, gb.ScrapedAt = 4060, now.Add(3*time.Minute)
+	if err := st.upsertListings([]Listing{gb}); err != nil {
+		t.Fatal(err)
+	}
+	if shared, err := st.matchMachines(); err != nil || shared != 1 {
+		t.Fatalf("matchMachines after rejoining = %d, %v; want 1", shared, err)
+	}
+	if got := storedListing(t, st, "agriaffaires", gb.ID); !got.Canonical || got.MachineID != id {
+		t.Errorf("rejoined agriaffaires advert: machine %d, canonical %v; want %d, true", got.MachineID, got.Canonical, id)
+	}
+	if _, err := st.markRemoved("agriaffaires", "", now.Add(time.Hour), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.matchMachines(); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedListing(t, st, "landwirt", at.ID); !got.Canonical {
+		t.Error("the active advert did not become canonical when the other was removed")
+	}
+}
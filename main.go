@@ -1,78 +1,379 @@
+// Command tractor_scraper collects used tractor adverts from several
+// marketplaces into a common format.
+//
+// Usage:
+//
+//	tractor_scraper scrape -source landwirt [-url URL] [-pages N] [-max-items N] [-images] [-models FILE] [-currency GBP] [-resume] [-cache | -offline] [-sites DIR]
+//	tractor_scraper scrape -source agriaffaires -make Fordson -min-year 1955 [-max-year Y] [-min-hp N] [-max-price P] [-country GB]
+//	tractor_scraper export [-source NAME] [-country AT] [-home "AT 4600" [-max-distance-km 150]] [-unique] [-drive 4WD] [-front-loader] [-attribute-columns] [-format csv|jsonl|parquet|xlsx] [-o FILE]
+//	tractor_scraper history [-source NAME] <listing-id>
+//	tractor_scraper changes [-since 7d]
+//	tractor_scraper dealers [-source NAME]
+//	tractor_scraper machines [-source NAME] [-all]
+//	tractor_scraper models [-source NAME] [-models FILE] [-update]
+//	tractor_scraper sources
+//	tractor_scraper doctor [-source NAME] [-sites DIR] [-proxy URL] [-ca-bundle FILE] [-cache | -offline]
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
-
-	"github.com/PuerkitoBio/goquery"
+	"syscall"
+	"time"
 )
 
-type Tractor struct {
-	Title       string
-	Price       string
-	HP          string
-	Year        string
-	WorkingHours string
-	Dealer      string
-	Location    string
-	ImageURL    string
+// defaultDB is the listing store used when -db is not given.
+const defaultDB = "tractors.db"
+
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"scrape":   {"crawl a source and save the results to the store", runScrape},
+	"export":   {"write the stored listings to a CSV, JSON Lines, Parquet or Excel file", runExport},
+	"history":  {"show the price and status timeline of a listing", runHistory},
+	"changes":  {"report price drops, new and removed listings", runChanges},
+	"dealers":  {"list dealers with their stock and price range", runDealers},
+	"machines": {"list machines advertised more than once, with every advert", runMachines},
+	"models":   {"list titles the model catalog cannot read", runModels},
+	"sources":  {"list the available sources", runSources},
+	"doctor":   {"check the site definitions against live pages", runDoctor},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: tractor_scraper <command> [flags]\n\nCommands:\n")
+	for _, name := range sortedKeys(commands) {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'tractor_scraper <command> -h' for the flags of a command.\n")
 }
 
 func main() {
-	url := "https://www.landwirt.com/en/used-farm-machinery/tractors.html"
+	log.SetFlags(log.LstdFlags)
 
-	resp, err := http.Get(url)
-	if err != nil {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tractor_scraper: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
 		log.Fatal(err)
 	}
-	defer resp.Body.Close()
+}
+
+func runScrape(args []string) error {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	sourceName := fs.String("source", "", "source to crawl: "+strings.Join(sourceNames(), ", "))
+	baseURL := fs.String("url", "", "listing URL to start from (default: the source's tractor listing)")
+	maxPages := fs.Int("pages", 0, "maximum number of listing pages to fetch (0 = all)")
+	maxItems := fs.Int("max-items", 0, "stop after this many listings (0 = all)")
+	details := fs.Bool("details", true, "also fetch each advert's detail page")
+	images := fs.Bool("images", false, "also fetch each advert's picture, to match adverts for the same machine")
+	dbPath := fs.String("db", defaultDB, "listing store to update")
+	currency := fs.String("currency", "", "also report prices converted to this currency, e.g. GBP")
+	ratesFile := fs.String("rates", "", "exchange rates file (CSV or JSON) of extra rates for -currency; see rates.csv")
+	resume := fs.Bool("resume", false, "continue the interrupted crawl recorded in the checkpoint file")
+	checkpointPath := fs.String("checkpoint", "", "checkpoint file (default: <db>.<source>.checkpoint.json)")
+	workers := fs.Int("workers", 0, "concurrent detail-page fetches (default: per source)")
+	rps := fs.Float64("rps", 0, "requests per second per host (default: per source)")
+	jitter := fs.Duration("jitter", 0, "maximum random extra delay per request (default: per source)")
+	var fetchFlags fetcherFlags
+	fetchFlags.addFlags(fs)
+	sitesDir := fs.String("sites", "", "directory of <source>.yaml/.json site definitions overriding the built-in selectors")
+	minFillFlag := fs.String("min-fill", "", "extra fill-rate thresholds that fail the run, e.g. price=0.9,dealer=0.5")
+	var modelsFiles modelFiles
+	fs.Var(&modelsFiles, "models", "YAML file of extra makes, series and models (repeatable); see models.yaml")
+	var filter searchFilter
+	filter.addFlags(fs)
+	fs.Parse(args)
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if *sourceName == "" {
+		fs.Usage()
+		return fmt.Errorf("-source is required")
+	}
+	src, err := lookupSource(*sourceName)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if *sitesDir != "" {
+		if err := loadSiteDefinitions(*sitesDir); err != nil {
+			return err
+		}
+	}
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	if *baseURL != "" && !filter.isZero() {
+		return fmt.Errorf("-url cannot be combined with search filters")
+	}
+	minFill, err := parseMinFill(*minFillFlag)
+	if err != nil {
+		return err
+	}
+	catalog, err := loadModelCatalog(modelsFiles...)
+	if err != nil {
+		return err
+	}
+	filter.useCatalog(catalog)
+	f, err := fetchFlags.open()
+	if err != nil {
+		return err
+	}
+	var rates *rateTable
+	if *currency != "" {
+		// Load the rates before crawling so a bad file fails fast.
+		var extra []string
+		if *ratesFile != "" {
+			extra = append(extra, *ratesFile)
+		}
+		if rates, err = loadRates(extra...); err != nil {
+			return err
+		}
+		*currency = strings.ToUpper(*currency)
+		if _, ok := rates.rate(*currency, time.Now()); !ok {
+			return fmt.Errorf("no exchange rates for %s; add them with -rates", *currency)
+		}
 	}
 
-	tractors := []Tractor{}
+	searchURL := *baseURL
+	if searchURL == "" {
+		var local []string
+		if searchURL, local, err = buildSearchURL(src, filter); err != nil {
+			return err
+		}
+		if slices.Contains(local, "make") {
+			log.Printf("WARNING: %s has no results page for %s, so every page of the category is read; "+
+				"add one under search: pages: in a -sites definition", src.Name(), filter.Make)
+		}
+		if len(local) > 0 {
+			log.Printf("%s cannot search by %s; filtering its results instead", src.Name(), strings.Join(local, ", "))
+		}
+	}
+	if *checkpointPath == "" {
+		*checkpointPath = fmt.Sprintf("%s.%s.checkpoint.json", strings.TrimSuffix(*dbPath, filepath.Ext(*dbPath)), src.Name())
+	}
+	state, err := openCrawlState(src.Name(), searchURL, *checkpointPath, *resume)
+	if err != nil {
+		return err
+	}
+	if *resume && len(state.Listings) > 0 {
+		log.Printf("Resuming crawl started %s: %d listings, %d detail pages done",
+			state.StartedAt.Format(time.DateTime), len(state.Listings), len(state.DetailsDone))
+	}
 
-	doc.Find(".row.gmmtreffer").Each(func(i int, s *goquery.Selection) {
-		tractor := Tractor{}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	policy := src.Politeness()
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "workers":
+			policy.Workers = *workers
+		case "rps":
+			policy.RequestsPerSecond = *rps
+		case "jitter":
+			policy.Jitter = *jitter
+		}
+	})
+	err = crawl(ctx, src, f, crawlOptions{MaxPages: *maxPages, MaxItems: *maxItems, Details: *details, Images: *images, Politeness: policy, Filter: filter}, state)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("crawl interrupted; progress saved to %s, rerun with -resume to continue", *checkpointPath)
+	case errors.Is(err, errBlocked):
+		return fmt.Errorf("%v; progress saved to %s, rerun later with -resume to continue", err, *checkpointPath)
+	case errors.Is(err, errNoListings):
+		if err := state.remove(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%v, so the site layout may have changed; nothing was saved (run 'tractor_scraper doctor -source %s')", err, src.Name())
+	case err != nil:
+		return err
+	}
+	var listings []Listing
+	unmatched := 0
+	for _, l := range state.Listings {
+		found := catalog.normalize(&l)
+		// The detail pages may have shown that some listings do not match.
+		if !filter.matches(&l) {
+			continue
+		}
+		listings = append(listings, l)
+		if !found {
+			unmatched++
+		}
+	}
+	// Checked whatever the count, as it must be before markRemoved below.
+	health := fillRates(listings, fillThresholds(site(src.Name()), *details, minFill))
+	if failed := printFillRates(os.Stdout, health); len(failed) > 0 {
+		// The listings were parsed wrongly, so resuming would not help.
+		if err := state.remove(); err != nil {
+			return err
+		}
+		return fmt.Errorf("fill rate below minimum for %s, so the site layout may have changed; "+
+			"nothing was saved (run 'tractor_scraper doctor -source %s')", strings.Join(failed, ", "), src.Name())
+	}
+	if rates != nil {
+		convertListings(rates, *currency, listings)
+	}
+
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	if err := st.upsertListings(listings); err != nil {
+		return err
+	}
+	// An offline crawl sees the site as it was when the pages were cached,
+	// which says nothing about what has been sold since.
+	if state.Complete && !fetchFlags.offline {
+		removed, err := st.markRemoved(src.Name(), searchURL, state.StartedAt, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Listings no longer found: %d\n", removed)
+	}
+	// After markRemoved, so that removed adverts stop being canonical.
+	shared, err := st.matchMachines()
+	if err != nil {
+		return err
+	}
+	if err := state.remove(); err != nil {
+		return err
+	}
+	fmt.Printf("Total tractors scraped: %d\n", len(listings))
+	fmt.Printf("Machines advertised more than once: %d\n", shared)
+	if unmatched > 0 {
+		fmt.Printf("Listings with no model in the catalog: %d (run 'tractor_scraper models' to list them)\n", unmatched)
+	}
+	fmt.Printf("Results saved to %s\n", *dbPath)
+	return nil
+}
 
-		tractor.Title = strings.TrimSpace(s.Find("h3 a").Text())
-		tractor.Price = strings.TrimSpace(s.Find(".gmmprice1, .pricetagbig").First().Text())
-		tractor.ImageURL, _ = s.Find(".bildboxgmm img").Attr("src")
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDB, "listing store to read")
+	sourceName := fs.String("source", "", "only export listings from this source")
+	country := fs.String("country", "", "only export listings in this country, e.g. AT or Austria")
+	home := fs.String("home", "", "add the distance from this country and postcode or town, e.g. \"AT 4600\"")
+	maxDistance := fs.Float64("max-distance-km", 0, "only export listings within this distance of -home (0 = any)")
+	gazetteerFile := fs.String("gazetteer", "", "CSV file of extra places for -home distances; see gazetteer.csv")
+	unique := fs.Bool("unique", false, "only export the canonical advert of each machine")
+	attributeColumns := fs.Bool("attribute-columns", false, "in CSV and XLSX, write each attribute in a column of its own rather than all in one")
+	format := fs.String("format", "", exportFormatUsage())
+	output := fs.String("o", "", "file to write (default results/listings.FORMAT)")
+	var features featureFilter
+	features.addFlags(fs)
+	fs.Parse(args)
+	if err := features.validate(); err != nil {
+		return err
+	}
+	formatName, err := exportFormatFor(*format, *output)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filepath.Join("results", "listings."+formatName)
+	}
 
-		s.Find(".gmmlistcatfield li").Each(func(i int, li *goquery.Selection) {
-			text := strings.TrimSpace(li.Text())
-			if strings.HasPrefix(text, "hp/kW:") {
-				tractor.HP = strings.TrimPrefix(text, "hp/kW:")
-			} else if strings.HasPrefix(text, "Year of construction:") {
-				tractor.Year = strings.TrimPrefix(text, "Year of construction:")
-			} else if strings.HasPrefix(text, "Working hours:") {
-				tractor.WorkingHours = strings.TrimPrefix(text, "Working hours:")
-			}
-		})
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
 
-		dealerInfo := strings.TrimSpace(s.Find("address").Text())
-		parts := strings.Split(dealerInfo, "-")
-		if len(parts) == 2 {
-			tractor.Dealer = strings.TrimSpace(parts[0])
-			tractor.Location = strings.TrimSpace(parts[1])
+	filter := listingFilter{Source: *sourceName}
+	if *country != "" {
+		if filter.Country = countryCode(*country); filter.Country == "" {
+			return fmt.Errorf("unknown country %q", *country)
 		}
-
-		tractors = append(tractors, tractor)
+	}
+	listings, err := st.queryListings(filter)
+	if err != nil {
+		return err
+	}
+	listings = slices.DeleteFunc(listings, func(l Listing) bool {
+		return *unique && !l.Canonical || !features.matches(&l)
 	})
+	if *maxDistance > 0 && *home == "" {
+		return fmt.Errorf("-max-distance-km needs -home")
+	}
+	if *home != "" {
+		if listings, err = withDistances(listings, *home, *maxDistance, *gazetteerFile); err != nil {
+			return err
+		}
+	}
+	if err := exportFormats[formatName].write(*output, listings, exportOptions{AttributeColumns: *attributeColumns}); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d listings to %s\n", len(listings), *output)
+	return nil
+}
+
+// withDistances sets the distance of each listing from home and, if
+// maxDistance is not 0, drops those farther away. Listings that cannot be
+// placed are dropped too, since they may be anywhere.
+func withDistances(listings []Listing, home string, maxDistance float64, gazetteerFile string) ([]Listing, error) {
+	var extra []string
+	if gazetteerFile != "" {
+		extra = append(extra, gazetteerFile)
+	}
+	g, err := loadGazetteer(extra...)
+	if err != nil {
+		return nil, err
+	}
+	from, err := g.parseHome(home)
+	if err != nil {
+		return nil, err
+	}
+	g.setDistances(listings, from)
+	if maxDistance == 0 {
+		return listings, nil
+	}
+
+	var near []Listing
+	unplaced := 0
+	for _, l := range listings {
+		switch {
+		case l.PlacedBy == "":
+			unplaced++
+		case l.DistanceKM <= maxDistance:
+			near = append(near, l)
+		}
+	}
+	if unplaced > 0 {
+		log.Printf("Left out %d listings without a known country", unplaced)
+	}
+	return near, nil
+}
 
-	for _, tractor := range tractors {
-		fmt.Printf("Title: %s\n", tractor.Title)
-		fmt.Printf("Price: %s\n", tractor.Price)
-		fmt.Printf("HP: %s\n", tractor.HP)
-		fmt.Printf("Year: %s\n", tractor.Year)
-		fmt.Printf("Working Hours: %s\n", tractor.WorkingHours)
-		fmt.Printf("Dealer: %s\n", tractor.Dealer)
-		fmt.Printf("Location: %s\n", tractor.Location)
-		fmt.Printf("Image URL: %s\n", tractor.ImageURL)
-		fmt.Println("------------------------")
-	}
-}
\ No newline at end of file
+func runSources(args []string) error {
+	for _, name := range sourceNames() {
+		fmt.Printf("%-14s %s\n", name, sources[name].DefaultURL())
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed models.yaml
+var builtinModels []byte
+
+// maxModelWords is the most title words a model name or pattern is matched
+// against: "New Performance Super Major" is four.
+const maxModelWords = 4
+
+// catalogMake is a make in the model catalog (see models.yaml).
+type catalogMake struct {
+	Name    string          `yaml:"make"`
+	Aliases []string        `yaml:"aliases"`
+	Series  []catalogSeries `yaml:"series"`
+}
+
+type catalogSeries struct {
+	Name    string         `yaml:"name"`
+	Aliases []string       `yaml:"aliases"`
+	Models  []catalogModel `yaml:"models"`
+	Pattern string         `yaml:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+type catalogModel struct {
+	Name    string   `yaml:"name"`
+	Aliases []string `yaml:"aliases"`
+}
+
+// UnmarshalYAML accepts a model's name alone as well as a mapping.
+func (m *catalogModel) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		m.Name = value.Value
+		return nil
+	}
+	type plain catalogModel
+	return value.Decode((*plain)(m))
+}
+
+// modelCatalog maps the ways adverts write makes, series and models to
+// their proper names.
+type modelCatalog struct {
+	makes []*catalogMake
+}
+
+// modelMatch is what the catalog makes of an advert. Fields it could not
+// tell are empty.
+type modelMatch struct {
+	Make, Series, Model string
+}
+
+// loadModelCatalog reads the built-in catalog and then each file in extra,
+// whose entries are added to the built-in ones.
+func loadModelCatalog(extra ...string) (*modelCatalog, error) {
+	c := &modelCatalog{}
+	if err := c.read(builtinModels); err != nil {
+		return nil, fmt.Errorf("error in built-in model catalog: %w", err)
+	}
+	for _, path := range extra {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading model catalog: %w", err)
+		}
+		if err := c.read(data); err != nil {
+			return nil, fmt.Errorf("error in model catalog %s: %w", path, err)
+		}
+	}
+	return c, nil
+}
+
+// read merges a catalog file into c.
+func (c *modelCatalog) read(data []byte) error {
+	var makes []catalogMake
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&makes); err != nil {
+		return err
+	}
+	for _, m := range makes {
+		if m.Name == "" {
+			return fmt.Errorf("make without a name")
+		}
+		mk := c.make(m.Name)
+		mk.Aliases = append(mk.Aliases, m.Aliases...)
+		for _, s := range m.Series {
+			if s.Name == "" {
+				return fmt.Errorf("%s: series without a name", m.Name)
+			}
+			series := mk.series(s.Name)
+			series.Aliases = append(series.Aliases, s.Aliases...)
+			if s.Pattern != "" {
+				re, err := regexp.Compile("^(?:" + s.Pattern + ")$")
+				if err != nil {
+					return fmt.Errorf("%s %s: invalid pattern: %w", m.Name, s.Name, err)
+				}
+				series.Pattern, series.pattern = s.Pattern, re
+			}
+			for _, model := range s.Models {
+				if model.Name == "" {
+					return fmt.Errorf("%s %s: model without a name", m.Name, s.Name)
+				}
+				i := slices.IndexFunc(series.Models, func(x catalogModel) bool { return compactName(x.Name) == compactName(model.Name) })
+				if i < 0 {
+					series.Models = append(series.Models, catalogModel{Name: model.Name})
+					i = len(series.Models) - 1
+				}
+				series.Models[i].Aliases = append(series.Models[i].Aliases, model.Aliases...)
+			}
+		}
+	}
+	return nil
+}
+
+// make returns the make named name, adding it if it is new.
+func (c *modelCatalog) make(name string) *catalogMake {
+	for _, m := range c.makes {
+		if compactName(m.Name) == compactName(name) {
+			return m
+		}
+	}
+	m := &catalogMake{Name: name}
+	c.makes = append(c.makes, m)
+	return m
+}
+
+func (m *catalogMake) series(name string) *catalogSeries {
+	for i := range m.Series {
+		if compactName(m.Series[i].Name) == compactName(name) {
+			return &m.Series[i]
+		}
+	}
+	m.Series = append(m.Series, catalogSeries{Name: name})
+	return &m.Series[len(m.Series)-1]
+}
+
+// titleWord is a run of letters and digits in a title, lower-cased, with
+// where it is in the title.
+type titleWord struct {
+	text       string
+	start, end int
+}
+
+// titleWords splits s into words at anything that is not a letter or a
+// digit, so "X4.70" is two words and "Deutz-Fahr" too.
+func titleWords(s string) []titleWord {
+	var words []titleWord
+	start := -1
+	for i, r := range s + " " {
+		alnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case alnum && start < 0:
+			start = i
+		case !alnum && start >= 0:
+			words = append(words, titleWord{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	return words
+}
+
+// compactName is how names are compared: lower case, letters and digits
+// only.
+func compactName(s string) string {
+	var b strings.Builder
+	for _, w := range titleWords(s) {
+		b.WriteString(w.text)
+	}
+	return b.String()
+}
+
+// span is a run of consecutive words of a title.
+type span struct {
+	key        string // the words run together
+	first, end int    // word indexes, end exclusive
+}
+
+// findSpan returns the longest run of at most maxModelWords words for which
+// match is true, preferring the later of two as long. Titles tend to go
+// from the general to the particular: in "Fordson Major Dexta" the model
+// is the Dexta.
+func findSpan(words []titleWord, match func(key string) bool) (span, bool) {
+	var best span
+	found := false
+	for i := range words {
+		key := ""
+		for j := i; j < len(words) && j < i+maxModelWords; j++ {
+			key += words[j].text
+			if len(key) >= len(best.key) && match(key) {
+				best, found = span{key, i, j + 1}, true
+			}
+		}
+	}
+	return best, found
+}
+
+// findMake returns the make mentioned in words, and where.
+func (c *modelCatalog) findMake(words []titleWord) (*catalogMake, span) {
+	var found *catalogMake
+	s, _ := findSpan(words, func(key string) bool {
+		for _, m := range c.makes {
+			if compactName(m.Name) == key || slices.ContainsFunc(m.Aliases, func(a string) bool { return compactName(a) == key }) {
+				found = m
+				return true
+			}
+		}
+		return false
+	})
+	return found, s
+}
+
+// findModel returns the series and model of mk mentioned in words, which
+// are from text. A listed model or alias is preferred to a pattern match;
+// failing both, the series may be found on its own.
+func (mk *catalogMake) findModel(text string, words []titleWord) (series, model string) {
+	_, ok := findSpan(words, func(key string) bool {
+		for _, s := range mk.Series {
+			for _, m := range s.Models {
+				if compactName(m.Name) == key || slices.ContainsFunc(m.Aliases, func(a string) bool { return compactName(a) == key }) {
+					series, model = s.Name, m.Name
+					return true
+				}
+			}
+		}
+		return false
+	})
+	if ok {
+		return series, model
+	}
+	if s, ok := findSpan(words, func(key string) bool {
+		for _, s := range mk.Series {
+			if s.pattern != nil && s.pattern.MatchString(key) {
+				series = s.Name
+				return true
+			}
+		}
+		return false
+	}); ok {
+		return series, modelName(text, words[s.first:s.end])
+	}
+	findSpan(words, func(key string) bool {
+		for _, s := range mk.Series {
+			if compactName(s.Name) == key || slices.ContainsFunc(s.Aliases, func(a string) bool { return compactName(a) == key }) {
+				series = s.Name
+				return true
+			}
+		}
+		return false
+	})
+	return series, ""
+}
+
+// modelName writes a model matched by a pattern as the title does, but
+// with model numbers upper-cased and other words capitalised: "x4.70" is
+// "X4.70" and "puma 165" "Puma 165".
+func modelName(text string, words []titleWord) string {
+	var b strings.Builder
+	for i, w := range words {
+		if i > 0 {
+			b.WriteString(text[words[i-1].end:w.start])
+		}
+		word := text[w.start:w.end]
+		if strings.ContainsFunc(word, unicode.IsDigit) {
+			b.WriteString(strings.ToUpper(word))
+		} else {
+			r := []rune(word)
+			b.WriteString(strings.ToUpper(string(r[0])) + string(r[1:]))
+		}
+	}
+	return b.String()
+}
+
+// match reads the make from the site's own make field or else the title,
+// and the model from the title or else the site's model field.
+func (c *modelCatalog) match(l *Listing) modelMatch {
+	mk, _ := c.findMake(titleWords(l.Make))
+	words := titleWords(l.Title)
+	if m, s := c.findMake(words); m != nil && (mk == nil || m == mk) {
+		// The make is not part of the model.
+		mk = m
+		words = slices.Delete(words, s.first, s.end)
+	}
+	if mk == nil {
+		return modelMatch{}
+	}
+
+	series, model := mk.findModel(l.Title, words)
+	if model == "" {
+		s, m := mk.findModel(l.Model, titleWords(l.Model))
+		switch {
+		case m != "":
+			series, model = s, m
+		case series == "":
+			series = s
+		}
+	}
+	return modelMatch{Make: mk.Name, Series: series, Model: model}
+}
+
+// normalize replaces the listing's make and model with the catalog's
+// names for them and fills in its series. What the catalog cannot tell is
+// left as the site gave it. It reports whether the model was found.
+func (c *modelCatalog) normalize(l *Listing) bool {
+	m := c.match(l)
+	if m.Make != "" {
+		l.Make = m.Make
+	}
+	if m.Series != "" {
+		l.Series = m.Series
+	}
+	if m.Model != "" {
+		l.Model = m.Model
+	}
+	return m.Model != ""
+}
+
+// unmatchedTitle is a title the catalog found no model in, with how many
+// stored listings have it.
+type unmatchedTitle struct {
+	Title, Make string
+	Listings    int
+}
+
+// unmatchedTitles normalizes listings and returns the titles whose model
+// was not found, the most common first.
+func (c *modelCatalog) unmatchedTitles(listings []Listing) []unmatchedTitle {
+	counts := make(map[string]*unmatchedTitle)
+	var titles []*unmatchedTitle
+	for i := range listings {
+		l := &listings[i]
+		if c.normalize(l) {
+			continue
+		}
+		key := strings.ToLower(strings.Join(strings.Fields(l.Title), " "))
+		u, ok := counts[key]
+		if !ok {
+			u = &unmatchedTitle{Title: l.Title, Make: l.Make}
+			counts[key] = u
+			titles = append(titles, u)
+		}
+		u.Listings++
+	}
+	slices.SortStableFunc(titles, func(a, b *unmatchedTitle) int {
+		return cmp.Or(b.Listings-a.Listings, strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)))
+	})
+	result := make([]unmatchedTitle, len(titles))
+	for i, u := range titles {
+		result[i] = *u
+	}
+	return result
+}
+
+// updateModels stores the make, series and model of each listing. A
+// listing whose names change is matched again by the next scrape.
+func (s *store) updateModels(listings []Listing) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`UPDATE listings SET make = ?1, series = ?2, model = ?3,
+	matched = CASE WHEN make = ?1 AND series = ?2 AND model = ?3 THEN matched ELSE 0 END
+WHERE source = ?4 AND id = ?5`)
+	if err != nil {
+		return fmt.Errorf("error preparing model update: %w", err)
+	}
+	defer stmt.Close()
+	for _, l := range listings {
+		if _, err := stmt.Exec(l.Make, l.Series, l.Model, l.Source, l.ID); err != nil {
+			return fmt.Errorf("error storing model of %s listing %s: %w", l.Source, l.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// modelFiles is a flag naming extra model catalog files, which may be
+// repeated.
+type modelFiles []string
+
+func (m *modelFiles) String() string { return strings.Join(*m, ",") }
+
+func (m *modelFiles) Set(path string) error {
+	*m = append(*m, path)
+	return nil
+}
+
+func runModels(args []string) error {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDB, "listing store to read")
+	sourceName := fs.String("source", "", "only check listings from this source")
+	var extra modelFiles
+	fs.Var(&extra, "models", "YAML file of extra makes, series and models (repeatable); see models.yaml")
+	update := fs.Bool("update", false, "store the makes, series and models found")
+	fs.Parse(args)
+
+	catalog, err := loadModelCatalog(extra...)
+	if err != nil {
+		return err
+	}
+	st, err := openStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	listings, err := st.queryListings(listingFilter{Source: *sourceName})
+	if err != nil {
+		return err
+	}
+	unmatched := catalog.unmatchedTitles(listings)
+	if *update {
+		if err := st.updateModels(listings); err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Listings\tMake\tTitle")
+	for _, u := range unmatched {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", u.Listings, u.Make, u.Title)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d of %d listings have no model in the catalog\n", sumListings(unmatched), len(listings))
+	return nil
+}
+
+func sumListings(titles []unmatchedTitle) int {
+	n := 0
+	for _, u := range titles {
+		n += u.Listings
+	}
+	return n
+}
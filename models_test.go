@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestModelCatalog(t *testing.T) {
+	catalog, err := loadModelCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		make, model, title string
+		want               modelMatch
+	}{
+		{"", "", "Fordson Major SUPER MAJOR", modelMatch{"Fordson", "Major", "Super Major"}},
+		{"", "", "Fordson Major Dexta", modelMatch{"Fordson", "Dexta", "Dexta"}},
+		{"", "", "Fordson E1A", modelMatch{"Fordson", "Major", ""}},
+		{"FORDSON", "MAJOR", "Tractor for restoration", modelMatch{"Fordson", "Major", "Major"}},
+		{"", "", "McCormick X4.70", modelMatch{"McCormick", "X4", "X4.70"}},
+		{"", "", "MCCORMICK x 4.70 Efficient", modelMatch{"McCormick", "X4", "X 4.70"}},
+		{"", "", "McCormick TTX190", modelMatch{"McCormick", "TTX", "TTX 190"}},
+		{"MASSEY FERGUSON", "5610", "Massey Ferguson 5610 Dyna-4", modelMatch{"Massey Ferguson", "5600", "5610"}},
+		{"", "", "MF 35X", modelMatch{"Massey Ferguson", "Classic", "35X"}},
+		{"", "", "John Deere 6130M", modelMatch{"John Deere", "6M", "6130M"}},
+		{"", "", "Fendt 724 Vario Profi", modelMatch{"Fendt", "700 Vario", "724 Vario"}},
+		{"Deutz", "", "Deutz-Fahr Agrotron 120", modelMatch{"Deutz-Fahr", "Agrotron", "Agrotron 120"}},
+		{"", "", "Lamborghini R4", modelMatch{}},
+	}
+	for _, tt := range tests {
+		got := catalog.match(&Listing{Make: tt.make, Model: tt.model, Title: tt.title})
+		if got != tt.want {
+			t.Errorf("match(%q, %q, %q) = %+v, want %+v", tt.make, tt.model, tt.title, got, tt.want)
+		}
+	}
+}
+
+// TestModelCatalogExtra adds a make and an alias from a file of its own
+// and reports the titles still not understood.
+func TestModelCatalogExtra(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.yaml")
+	extra := `
+- make: Lamborghini
+  series:
+    - name: R
+      pattern: 'r\d'
+- make: Fordson
+  series:
+    - name: Major
+      models:
+        - name: Super Major
+          aliases: [SM]
+`
+	if err := os.WriteFile(path, []byte(extra), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := loadModelCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listings := []Listing{
+		{Title: "Lamborghini R4"},
+		{Title: "Fordson SM"},
+		{Title: "Ursus C-360"},
+		{Title: "URSUS  C-360"},
+		{Title: "Fordson Major"},
+	}
+	unmatched := catalog.unmatchedTitles(listings)
+	if len(unmatched) != 1 || unmatched[0].Listings != 2 || unmatched[0].Title != "Ursus C-360" {
+		t.Errorf("unmatched = %+v", unmatched)
+	}
+	if l := listings[0]; l.Make != "Lamborghini" || l.Series != "R" || l.Model != "R4" {
+		t.Errorf("Lamborghini R4 read as %s / %s / %s", l.Make, l.Series, l.Model)
+	}
+	if l := listings[1]; l.Model != "Super Major" {
+		t.Errorf("Fordson SM read as model %q", l.Model)
+	}
+
+	if err := os.WriteFile(path, []byte("- make: Fendt\n  series:\n    - name: Vario\n      pattern: '('\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadModelCatalog(path); err == nil {
+		t.Error("no error for an invalid pattern")
+	}
+}
+
+// TestUpdateModels renames one of two matched listings and expects only it
+// to be matched again.
+func TestUpdateModels(t *testing.T) {
+	st := testStore(t)
+	now := time.Now()
+	listings := []Listing{
+		{Source: "landwirt", ID: "1", Make: "Fordson", Model: "Major", ScrapedAt: now},
+		{Source: "landwirt", ID: "2", Make: "Fordson", Model: "Dexta", ScrapedAt: now},
+	}
+	if err := st.upsertListings(listings); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.matchMachines(); err != nil {
+		t.Fatal(err)
+	}
+	listings[1].Series, listings[1].Model = "Dexta", "Super Dexta"
+	if err := st.updateModels(listings); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := st.db.Query("SELECT id FROM listings WHERE matched = 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var unmatched []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		unmatched = append(unmatched, id)
+	}
+	if !slices.Equal(unmatched, []string{"2"}) {
+		t.Errorf("unmatched after renaming listing 2: %v", unmatched)
+	}
+}
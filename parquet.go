@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The Parquet export is written by hand rather than with a Parquet library:
+// a single row group of flat, optional, plain-encoded and uncompressed
+// columns is all it needs, and is little enough to write directly. Nested
+// fields (attributes, equipment, contacts, PTO speeds) are JSON strings,
+// which notebooks can expand. See
+// https://parquet.apache.org/docs/file-format/.
+//
+// Every listing goes into that one row group, and each column into one
+// data page, so the file is built in memory and a reader has to load a
+// whole column at once. That suits the store's tens of thousands of
+// listings; a column over 2 GiB, the most a page can hold, is an error.
+
+// parquetKind is the type of a Parquet column.
+type parquetKind int
+
+const (
+	parquetString parquetKind = iota
+	parquetJSON
+	parquetInt
+	parquetFloat
+	parquetBool
+	parquetTime
+)
+
+// parquetColumn is one column of the Parquet export. Its value is nil if
+// unknown, or else a string, int64, float64, bool or time.Time as its kind
+// says.
+type parquetColumn struct {
+	Name  string
+	Kind  parquetKind
+	value func(l *Listing) any
+}
+
+// parquetColumns are the columns of the Parquet export, named after the
+// listing store's columns.
+var parquetColumns = []parquetColumn{
+	{"source", parquetString, func(l *Listing) any { return optString(l.Source) }},
+	{"id", parquetString, func(l *Listing) any { return optString(l.ID) }},
+	{"url", parquetString, func(l *Listing) any { return optString(l.URL) }},
+	{"machine_id", parquetInt, func(l *Listing) any { return optInt(int(l.MachineID)) }},
+	{"canonical", parquetBool, func(l *Listing) any { return l.Canonical }},
+	{"first_seen", parquetTime, func(l *Listing) any { return optTime(l.FirstSeen) }},
+	{"last_seen", parquetTime, func(l *Listing) any { return optTime(l.ScrapedAt) }},
+	{"title", parquetString, func(l *Listing) any { return optString(l.Title) }},
+	{"make", parquetString, func(l *Listing) any { return optString(l.Make) }},
+	{"series", parquetString, func(l *Listing) any { return optString(l.Series) }},
+	{"model", parquetString, func(l *Listing) any { return optString(l.Model) }},
+	{"year", parquetInt, func(l *Listing) any { return optInt(l.Year) }},
+	{"hours", parquetInt, func(l *Listing) any { return optInt(l.Hours) }},
+	{"power_hp", parquetInt, func(l *Listing) any { return optInt(l.PowerHP) }},
+	{"power_kw", parquetInt, func(l *Listing) any { return optInt(l.PowerKW) }},
+	{"condition", parquetString, func(l *Listing) any { return optString(l.Condition) }},
+	{"drive", parquetString, func(l *Listing) any { return optString(l.Features.Drive) }},
+	{"transmission", parquetString, func(l *Listing) any { return optString(l.Features.Transmission) }},
+	{"pto_speeds", parquetJSON, func(l *Listing) any { return optJSON(l.Features.PTOSpeeds, len(l.Features.PTOSpeeds)) }},
+	{"front_loader", parquetBool, func(l *Listing) any { return optFitted(l.Features.FrontLoader) }},
+	{"cab", parquetBool, func(l *Listing) any { return optFitted(l.Features.Cab) }},
+	{"max_speed_kmh", parquetInt, func(l *Listing) any { return optInt(l.Features.MaxSpeedKMH) }},
+	{"front_tyres", parquetString, func(l *Listing) any { return optString(l.Features.FrontTyres) }},
+	{"rear_tyres", parquetString, func(l *Listing) any { return optString(l.Features.RearTyres) }},
+	{"price", parquetFloat, func(l *Listing) any { return optFloat(l.Price.Amount) }},
+	{"price_currency", parquetString, func(l *Listing) any { return optString(l.Price.Currency) }},
+	{"price_vat_included", parquetBool, func(l *Listing) any {
+		if l.Price.Amount == 0 {
+			return nil
+		}
+		return l.Price.VATIncluded
+	}},
+	{"price_vat_rate", parquetFloat, func(l *Listing) any { return optFloat(l.Price.VATRate) }},
+	{"price_original", parquetFloat, func(l *Listing) any { return optFloat(l.Price.OriginalAmount) }},
+	{"price_text", parquetString, func(l *Listing) any { return optString(l.PriceText) }},
+	{"price_reporting", parquetFloat, func(l *Listing) any { return optFloat(l.PriceInReportingCurrency) }},
+	{"reporting_currency", parquetString, func(l *Listing) any { return optString(l.ReportingCurrency) }},
+	{"dealer", parquetString, func(l *Listing) any { return optString(l.Dealer.Name) }},
+	{"dealer_address", parquetString, func(l *Listing) any { return optString(l.Dealer.Address) }},
+	{"dealer_postcode", parquetString, func(l *Listing) any { return optString(l.Dealer.Postcode) }},
+	{"dealer_country", parquetString, func(l *Listing) any { return optString(l.Dealer.Country) }},
+	{"dealer_url", parquetString, func(l *Listing) any { return optString(l.Dealer.URL) }},
+	{"dealer_contacts", parquetJSON, func(l *Listing) any { return optJSON(l.Dealer.Contacts, len(l.Dealer.Contacts)) }},
+	{"location", parquetString, func(l *Listing) any { return optString(l.Location) }},
+	{"postcode", parquetString, func(l *Listing) any { return optString(l.Place.Postcode) }},
+	{"city", parquetString, func(l *Listing) any { return optString(l.Place.City) }},
+	{"region", parquetString, func(l *Listing) any { return optString(l.Place.Region) }},
+	{"country", parquetString, func(l *Listing) any { return optString(l.Place.Country) }},
+	{"distance_km", parquetFloat, func(l *Listing) any {
+		if l.PlacedBy == "" {
+			return nil
+		}
+		return l.DistanceKM
+	}},
+	{"placed_by", parquetString, func(l *Listing) any { return optString(l.PlacedBy) }},
+	{"image_url", parquetString, func(l *Listing) any { return optString(l.ImageURL) }},
+	{"image_hash", parquetString, func(l *Listing) any { return optString(l.ImageHash) }},
+	{"description", parquetString, func(l *Listing) any { return optString(l.Description) }},
+	{"attributes", parquetJSON, func(l *Listing) any { return optJSON(l.Attributes, len(l.Attributes)) }},
+	{"equipment", parquetJSON, func(l *Listing) any { return optJSON(l.Equipment, len(l.Equipment)) }},
+}
+
+// Like the CSV export, unknown (zero) values are left out.
+
+func optString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func optInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return int64(n)
+}
+
+func optFloat(f float64) any {
+	if f == 0 {
+		return nil
+	}
+	return f
+}
+
+func optTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// optFitted is true for equipment a listing mentions and unknown
+// otherwise; see formatFitted.
+func optFitted(b bool) any {
+	if !b {
+		return nil
+	}
+	return true
+}
+
+// optJSON encodes v, which has n elements, or is nil if it has none.
+func optJSON(v any, n int) any {
+	if n == 0 {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
+// Parquet format constants.
+const (
+	parquetMagic = "PAR1"
+
+	// Physical types.
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	// Converted types.
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+	parquetJSONType        = 19
+
+	parquetOptional     = 1
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// physicalType returns the Parquet physical and converted types of k; the
+// converted type is -1 if there is none.
+func (k parquetKind) physicalType() (int32, int32) {
+	switch k {
+	case parquetString:
+		return parquetByteArray, parquetUTF8
+	case parquetJSON:
+		return parquetByteArray, parquetJSONType
+	case parquetInt:
+		return parquetInt64, -1
+	case parquetFloat:
+		return parquetDouble, -1
+	case parquetBool:
+		return parquetBoolean, -1
+	}
+	return parquetInt64, parquetTimestampMillis
+}
+
+// exportParquet writes listings to a Parquet file.
+func exportParquet(filename string, listings []Listing, opts exportOptions) error {
+	file, err := createExportFile(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := encodeParquet(listings)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("error writing Parquet file: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing Parquet file: %w", err)
+	}
+	return file.Close()
+}
+
+// encodeParquet returns listings as a Parquet file: the magic number, a
+// single row group with a column chunk of one data page for each column,
+// and the file metadata. Parquet gives page sizes as int32, which limits
+// each column to 2 GiB.
+func encodeParquet(listings []Listing) ([]byte, error) {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	// The row group's metadata is written along with its column chunks,
+	// since their offsets are only known then. An empty file has none.
+	var rowGroup thriftWriter
+	rowGroup.begin()
+	rowGroup.list(1, thriftStruct, len(parquetColumns))
+	for _, c := range parquetColumns {
+		if len(listings) == 0 {
+			break
+		}
+		offset := int64(file.Len())
+		page := encodeParquetPage(c, listings)
+		if len(page) > math.MaxInt32 || len(listings) > math.MaxInt32 {
+			return nil, fmt.Errorf("error writing Parquet file: column %s is too large for one page", c.Name)
+		}
+		var header thriftWriter
+		header.begin()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.beginField(5)
+		header.i32(1, int32(len(listings)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+		file.Write(header.Bytes())
+		file.Write(page)
+		size := int64(file.Len()) - offset
+
+		typ, _ := c.Kind.physicalType()
+		rowGroup.begin()
+		rowGroup.i64(2, offset)
+		rowGroup.beginField(3)
+		rowGroup.i32(1, typ)
+		rowGroup.list(2, thriftI32, 2)
+		rowGroup.varint(zigzag(parquetPlain))
+		rowGroup.varint(zigzag(parquetRLE))
+		rowGroup.list(3, thriftBinary, 1)
+		rowGroup.binaryValue(c.Name)
+		rowGroup.i32(4, parquetUncompressed)
+		rowGroup.i64(5, int64(len(listings)))
+		rowGroup.i64(6, size)
+		rowGroup.i64(7, size)
+		rowGroup.i64(9, offset)
+		rowGroup.end()
+		rowGroup.end()
+	}
+	rowGroup.i64(2, int64(file.Len()-len(parquetMagic)))
+	rowGroup.i64(3, int64(len(listings)))
+	rowGroup.end()
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(parquetColumns)+1)
+	meta.begin()
+	meta.binary(4, "listing")
+	meta.i32(5, int32(len(parquetColumns)))
+	meta.end()
+	for _, c := range parquetColumns {
+		typ, converted := c.Kind.physicalType()
+		meta.begin()
+		meta.i32(1, typ)
+		meta.i32(3, parquetOptional)
+		meta.binary(4, c.Name)
+		if converted >= 0 {
+			meta.i32(6, converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(listings)))
+	if len(listings) > 0 {
+		meta.list(4, thriftStruct, 1)
+		meta.Write(rowGroup.Bytes())
+	} else {
+		meta.list(4, thriftStruct, 0)
+	}
+	meta.binary(6, "tractor_scraper")
+	meta.end()
+
+	if meta.Len() > math.MaxInt32 {
+		return nil, fmt.Errorf("error writing Parquet file: metadata too large")
+	}
+	file.Write(meta.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(meta.Len())))
+	file.WriteString(parquetMagic)
+	return file.Bytes(), nil
+}
+
+// encodeParquetPage returns the values of column c as the body of a data
+// page: the definition levels, 1 where there is a value and 0 where it is
+// unknown, then the values.
+func encodeParquetPage(c parquetColumn, listings []Listing) []byte {
+	var levels, values []byte
+	var bits []bool
+	run, prev := 0, -1
+	flush := func() {
+		if run > 0 {
+			levels = binary.AppendUvarint(levels, uint64(run)<<1)
+			levels = append(levels, byte(prev))
+		}
+	}
+	for i := range listings {
+		v := c.value(&listings[i])
+		level := 0
+		if v != nil {
+			level = 1
+		}
+		if level != prev {
+			flush()
+			run, prev = 0, level
+		}
+		run++
+
+		switch v := v.(type) {
+		case string:
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(v)))
+			values = append(values, v...)
+		case int64:
+			values = binary.LittleEndian.AppendUint64(values, uint64(v))
+		case float64:
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+		case time.Time:
+			values = binary.LittleEndian.AppendUint64(values, uint64(v.UnixMilli()))
+		case bool:
+			bits = append(bits, v)
+		}
+	}
+	flush()
+	if c.Kind == parquetBool {
+		values = make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				values[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+
+	page := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	page = append(page, levels...)
+	return append(page, values...)
+}
+
+// Thrift compact protocol types, for the Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift structs of the Parquet metadata in the
+// compact protocol. Fields must be written in increasing order of ID.
+type thriftWriter struct {
+	bytes.Buffer
+	last  int16
+	outer []int16
+}
+
+// begin starts a struct that is not a field, such as a list element.
+func (w *thriftWriter) begin() {
+	w.outer = append(w.outer, w.last)
+	w.last = 0
+}
+
+// beginField starts a struct that is field id of the current one.
+func (w *thriftWriter) beginField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+func (w *thriftWriter) end() {
+	w.WriteByte(0)
+	w.last = w.outer[len(w.outer)-1]
+	w.outer = w.outer[:len(w.outer)-1]
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.WriteByte(typ)
+		w.varint(zigzag(int64(id)))
+	}
+	w.last = id
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	w.Write(binary.AppendUvarint(nil, v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.binaryValue(s)
+}
+
+func (w *thriftWriter) binaryValue(s string) {
+	w.varint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+// list starts a list field of n elements of type typ, which the caller
+// then writes.
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.WriteByte(byte(n)<<4 | typ)
+	} else {
+		w.WriteByte(0xf0 | typ)
+		w.varint(uint64(n))
+	}
+}
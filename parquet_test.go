@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The Parquet export is read back with the reader below, written from the
+// format specification rather than from encodeParquet: it decodes the Thrift
+// metadata generically and reads definition levels in either hybrid run
+// form, as any reader must.
+
+// thriftReader decodes Thrift compact protocol structs into maps from
+// field ID to value.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+var errThrift = errors.New("malformed thrift")
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		panic(errThrift)
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		panic(errThrift)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case 1, 2: // bool in a list; in a field the type holds it
+		return r.byte() == 1
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, 5, 6:
+		return r.varint()
+	case 7:
+		if r.pos+8 > len(r.data) {
+			panic(errThrift)
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos-8:]))
+	case 8:
+		n := int(r.uvarint())
+		if n < 0 || r.pos+n > len(r.data) {
+			panic(errThrift)
+		}
+		r.pos += n
+		return string(r.data[r.pos-n : r.pos])
+	case 9, 10:
+		head := r.byte()
+		n, elem := int(head>>4), head&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case 12:
+		return r.readStruct()
+	}
+	panic(fmt.Errorf("%w: type %d", errThrift, typ))
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		head := r.byte()
+		if head == 0 {
+			return fields
+		}
+		typ := head & 0x0f
+		if delta := int16(head >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+		switch typ {
+		case 1:
+			fields[id] = true
+		case 2:
+			fields[id] = false
+		default:
+			fields[id] = r.value(typ)
+		}
+	}
+}
+
+// readThrift decodes the struct at data[pos:], returning it and the
+// position after it.
+func readThrift(data []byte, pos int) (s map[int16]any, end int, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("at byte %d: %v", pos, p)
+		}
+	}()
+	r := &thriftReader{data: data, pos: pos}
+	s = r.readStruct()
+	return s, r.pos, nil
+}
+
+// readLevels decodes n definition levels of bit width 1 in the RLE/bit-packed
+// hybrid encoding.
+func readLevels(data []byte, n int) ([]int, error) {
+	var levels []int
+	for pos := 0; len(levels) < n; {
+		head, k := binary.Uvarint(data[pos:])
+		if k <= 0 {
+			return nil, fmt.Errorf("bad run header at %d", pos)
+		}
+		pos += k
+		if head&1 == 0 { // RLE run: count, then the value in one byte
+			if pos >= len(data) {
+				return nil, fmt.Errorf("truncated run at %d", pos)
+			}
+			for range head >> 1 {
+				levels = append(levels, int(data[pos]))
+			}
+			pos++
+			continue
+		}
+		for range (head >> 1) * 8 { // bit-packed groups of eight
+			i := len(levels) % 8
+			if pos+len(levels)/8 > len(data) {
+				return nil, fmt.Errorf("truncated bit-packed run")
+			}
+			levels = append(levels, int(data[pos]>>i&1))
+			if i == 7 {
+				pos++
+			}
+		}
+	}
+	if len(levels) < n {
+		return nil, fmt.Errorf("%d levels for %d values", len(levels), n)
+	}
+	return levels[:n], nil
+}
+
+// parquetColumnData is one column read back from a Parquet file.
+type parquetColumnData struct {
+	Type, Converted int64
+	Values          []any // nil where the value is null
+}
+
+// readParquet decodes a file written by encodeParquet into its columns by
+// name, with the number of rows.
+func readParquet(data []byte) (map[string]*parquetColumnData, int64, error) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, 0, fmt.Errorf("no PAR1 magic number")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if n <= 0 || n > len(data)-12 {
+		return nil, 0, fmt.Errorf("metadata length %d in a file of %d bytes", n, len(data))
+	}
+	meta, end, err := readThrift(data, len(data)-8-n)
+	if err != nil {
+		return nil, 0, err
+	}
+	if end != len(data)-8 {
+		return nil, 0, fmt.Errorf("metadata ends at %d, not %d", end, len(data)-8)
+	}
+
+	rows := meta[3].(int64)
+	columns := make(map[string]*parquetColumnData)
+	schema := meta[2].([]any)
+	for _, e := range schema[1:] {
+		el := e.(map[int16]any)
+		if el[3].(int64) != 1 {
+			return nil, 0, fmt.Errorf("column %s is not optional", el[4])
+		}
+		c := &parquetColumnData{Type: el[1].(int64), Converted: -1}
+		if conv, ok := el[6]; ok {
+			c.Converted = conv.(int64)
+		}
+		columns[el[4].(string)] = c
+	}
+	if root := schema[0].(map[int16]any); root[5].(int64) != int64(len(schema)-1) {
+		return nil, 0, fmt.Errorf("root has %d children for %d columns", root[5], len(schema)-1)
+	}
+
+	for _, g := range meta[4].([]any) {
+		group := g.(map[int16]any)
+		if group[3].(int64) != rows {
+			return nil, 0, fmt.Errorf("row group of %d rows in a file of %d", group[3], rows)
+		}
+		for _, cc := range group[1].([]any) {
+			chunk := cc.(map[int16]any)[3].(map[int16]any)
+			name := chunk[3].([]any)[0].(string)
+			c := columns[name]
+			if c == nil || chunk[1].(int64) != c.Type || chunk[4].(int64) != 0 {
+				return nil, 0, fmt.Errorf("column chunk %s does not match the schema or is compressed", name)
+			}
+			offset := int(chunk[9].(int64))
+			header, pos, err := readThrift(data, offset)
+			if err != nil {
+				return nil, 0, fmt.Errorf("page header of %s: %w", name, err)
+			}
+			size := int(header[3].(int64))
+			if header[1].(int64) != 0 || size != int(header[2].(int64)) || pos+size > len(data) ||
+				int64(pos+size-offset) != chunk[7].(int64) {
+				return nil, 0, fmt.Errorf("page of %s: bad header %v", name, header)
+			}
+			dp := header[5].(map[int16]any)
+			if dp[2].(int64) != 0 || dp[3].(int64) != 3 {
+				return nil, 0, fmt.Errorf("page of %s is not plain with RLE levels", name)
+			}
+			if c.Values, err = readPage(data[pos:pos+size], c.Type, int(dp[1].(int64))); err != nil {
+				return nil, 0, fmt.Errorf("page of %s: %w", name, err)
+			}
+		}
+	}
+	return columns, rows, nil
+}
+
+// readPage decodes n values of physical type typ from a data page body.
+func readPage(page []byte, typ int64, n int) ([]any, error) {
+	if len(page) < 4 {
+		return nil, fmt.Errorf("truncated page")
+	}
+	size := int(binary.LittleEndian.Uint32(page))
+	if 4+size > len(page) {
+		return nil, fmt.Errorf("levels overrun the page")
+	}
+	levels, err := readLevels(page[4:4+size], n)
+	if err != nil {
+		return nil, err
+	}
+	data := page[4+size:]
+	values := make([]any, n)
+	pos, bit := 0, 0
+	for i, level := range levels {
+		if level == 0 {
+			continue
+		}
+		switch typ {
+		case 0:
+			if bit/8 >= len(data) {
+				return nil, fmt.Errorf("truncated booleans")
+			}
+			values[i] = data[bit/8]>>(bit%8)&1 == 1
+			bit++
+			continue
+		case 2:
+			values[i] = int64(binary.LittleEndian.Uint64(data[pos:]))
+			pos += 8
+		case 5:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[pos:]))
+			pos += 8
+		case 6:
+			k := int(binary.LittleEndian.Uint32(data[pos:]))
+			values[i] = string(data[pos+4 : pos+4+k])
+			pos += 4 + k
+		default:
+			return nil, fmt.Errorf("unexpected type %d", typ)
+		}
+	}
+	if typ == 0 {
+		pos = (bit + 7) / 8
+	}
+	if pos != len(data) {
+		return nil, fmt.Errorf("%d bytes left after the values", len(data)-pos)
+	}
+	return values, nil
+}
+
+// parquetTestListings are exportListings and enough more for long runs of
+// definition levels, with gaps in the boolean and float columns.
+func parquetTestListings() []Listing {
+	listings := exportListings()
+	for i := range 150 {
+		l := Listing{Source: "landwirt", ID: strconv.Itoa(5000000 + i), Title: fmt.Sprintf("Tractor %d", i)}
+		switch {
+		case i%3 == 0:
+			l.Price = Price{Amount: float64(10000 + i), Currency: "EUR", VATIncluded: i%2 == 0, VATRate: 20}
+		case i%3 == 1:
+			l.Features.FrontLoader = true
+		}
+		if i >= 80 {
+			l.Year, l.FirstSeen = 1950+i%70, time.Date(2024, 1, 1, 0, 0, i, int(time.Millisecond)*i, time.UTC)
+		}
+		listings = append(listings, l)
+	}
+	return listings
+}
+
+func TestExportParquet(t *testing.T) {
+	for _, listings := range [][]Listing{parquetTestListings(), exportListings()[:1], nil} {
+		data, err := encodeParquet(listings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, rows, err := readParquet(data)
+		if err != nil {
+			t.Fatalf("reading %d listings back: %v", len(listings), err)
+		}
+		if rows != int64(len(listings)) || len(columns) != len(parquetColumns) {
+			t.Fatalf("read %d rows, %d columns; want %d, %d", rows, len(columns), len(listings), len(parquetColumns))
+		}
+		for _, c := range parquetColumns {
+			got := columns[c.Name]
+			typ, converted := c.Kind.physicalType()
+			if got.Type != int64(typ) || got.Converted != int64(converted) {
+				t.Errorf("column %s has types %d, %d; want %d, %d", c.Name, got.Type, got.Converted, typ, converted)
+			}
+			if len(listings) == 0 {
+				continue
+			}
+			if len(got.Values) != len(listings) {
+				t.Errorf("column %s has %d values for %d rows", c.Name, len(got.Values), len(listings))
+				continue
+			}
+			for i := range listings {
+				want := c.value(&listings[i])
+				if tm, ok := want.(time.Time); ok {
+					want = tm.UnixMilli()
+				}
+				if !reflect.DeepEqual(got.Values[i], want) {
+					t.Errorf("row %d %s = %#v, want %#v", i, c.Name, got.Values[i], want)
+				}
+			}
+		}
+	}
+}
+
+// TestExportParquetGaps spells out a boolean column with gaps, since
+// booleans are packed eight to a byte over the non-null values only.
+func TestExportParquetGaps(t *testing.T) {
+	listings := []Listing{
+		{Price: Price{Amount: 1, VATIncluded: true}},
+		{},
+		{Price: Price{Amount: 1}},
+		{},
+		{},
+		{Price: Price{Amount: 1, VATIncluded: true}},
+	}
+	data, err := encodeParquet(listings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, _, err := readParquet(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{true, nil, false, nil, nil, true}
+	if got := columns["price_vat_included"].Values; !reflect.DeepEqual(got, want) {
+		t.Errorf("price_vat_included = %v, want %v", got, want)
+	}
+	if got := columns["front_loader"].Values; !reflect.DeepEqual(got, make([]any, len(listings))) {
+		t.Errorf("front_loader = %v, want all null", got)
+	}
+}
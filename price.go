@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Price is an asking price as advertised.
+type Price struct {
+	Amount   float64
+	Currency string // ISO 4217 code, "" if the site did not say
+	// VATIncluded is true when Amount is a gross price. It is false both for
+	// net prices and when the advert does not say.
+	VATIncluded bool
+	VATRate     float64 // percent, 0 if not stated
+	// OriginalAmount is the struck-through price of a discounted advert,
+	// 0 if the price has not been reduced.
+	OriginalAmount float64
+}
+
+// PriceError reports a price string that could not be read.
+type PriceError struct {
+	Text string
+}
+
+func (e *PriceError) Error() string {
+	return fmt.Sprintf("could not parse price %q", e.Text)
+}
+
+var (
+	amountRe      = regexp.MustCompile(`\d+(?:[.,']\d+|[ \x{00a0}\x{202f}]\d{3}\b)*`)
+	vatRateRe     = regexp.MustCompile(`(\d+(?:[.,]\d+)?)\s*%`)
+	vatExcludedRe = regexp.MustCompile(`(?i)\b(?:excl?|exkl|ex|zzgl|plus|net|netto)\b\.?[\s-]*(?:vat|mwst|ust|moms|mva)\b|\bnet\b`)
+	vatIncludedRe = regexp.MustCompile(`(?i)\b(?:incl?|inkl|including)\b\.?[\s-]*(?:vat|mwst|ust|moms|mva)\b`)
+	onRequestRe   = regexp.MustCompile(`(?i)\b(?:poa|on request|auf anfrage|price on application)\b`)
+)
+
+var currencySymbols = []struct{ symbol, code string }{
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"CHF", "CHF"},
+	{"Fr.", "CHF"},
+	{"zł", "PLN"},
+	{"Kč", "CZK"},
+	{"Ft", "HUF"},
+	{"US$", "USD"},
+	{"$", "USD"},
+}
+
+var currencyCodes = map[string]bool{
+	"EUR": true, "GBP": true, "NOK": true, "DKK": true, "SEK": true, "CHF": true,
+	"PLN": true, "CZK": true, "HUF": true, "RON": true, "BGN": true, "USD": true,
+}
+
+var currencyCodeRe = regexp.MustCompile(`\b[A-Z]{3}\b`)
+
+// parsePrice reads an advertised price such as "EUR 116.904",
+// "97.420 excl. VAT 20%" or "36,950 £ ex-VAT". Adverts with no price
+// ("POA", "on request") give a zero Price and no error; anything else
+// without a readable amount gives a *PriceError.
+func parsePrice(text string) (Price, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || onRequestRe.MatchString(text) {
+		return Price{}, nil
+	}
+
+	var p Price
+	p.Currency = parseCurrency(text)
+
+	if m := vatRateRe.FindStringSubmatch(text); m != nil {
+		p.VATRate, _ = strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+		// Keep the rate out of the amount search.
+		text = strings.Replace(text, m[0], " ", 1)
+	}
+	p.VATIncluded = vatIncludedRe.MatchString(text) && !vatExcludedRe.MatchString(text)
+
+	amount, ok := parseAmount(amountRe.FindString(text))
+	if !ok {
+		return Price{}, &PriceError{Text: text}
+	}
+	p.Amount = amount
+	return p, nil
+}
+
+// parseCurrency finds an ISO code or a currency symbol in s.
+func parseCurrency(s string) string {
+	for _, code := range currencyCodeRe.FindAllString(s, -1) {
+		if currencyCodes[code] {
+			return code
+		}
+	}
+	for _, c := range currencySymbols {
+		if strings.Contains(s, c.symbol) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// parseAmount reads a number written with either European or English
+// separators. When both '.' and ',' appear the later one is the decimal
+// mark. When only one appears it is a thousands separator if it repeats or
+// is followed by exactly three digits ("116.904", "36,950"), otherwise a
+// decimal mark ("12,5").
+func parseAmount(s string) (float64, bool) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\'', '\u00a0', '\u202f':
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return 0, false
+	}
+
+	dot, comma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		if dot > comma {
+			s = strings.ReplaceAll(s, ",", "")
+		} else {
+			s = strings.ReplaceAll(s, ".", "")
+			s = strings.Replace(s, ",", ".", 1)
+		}
+	case dot >= 0:
+		s = normalizeSeparator(s, ".")
+	case comma >= 0:
+		s = normalizeSeparator(s, ",")
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+func normalizeSeparator(s, sep string) string {
+	last := strings.LastIndex(s, sep)
+	if strings.Count(s, sep) > 1 || len(s)-last-1 == 3 {
+		return strings.ReplaceAll(s, sep, "")
+	}
+	return strings.Replace(s, sep, ".", 1)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Price
+	}{
+		{"EUR 116.904", Price{Amount: 116904, Currency: "EUR"}},
+		{"€ 97.420 inkl. MwSt", Price{Amount: 97420, Currency: "EUR", VATIncluded: true}},
+		{"€ 45.000 inkl. 20% MwSt", Price{Amount: 45000, Currency: "EUR", VATIncluded: true, VATRate: 20}},
+		{"97.420 exkl. MwSt 20%", Price{Amount: 97420, VATRate: 20}},
+		// The headline amount is the net one when the gross follows it.
+		{"€ 80.000 exkl. MwSt (inkl. MwSt € 96.000)", Price{Amount: 80000, Currency: "EUR"}},
+		{"81.183 € zzgl. MwSt", Price{Amount: 81183, Currency: "EUR"}},
+		{"36,950 £ ex-VAT", Price{Amount: 36950, Currency: "GBP"}},
+		{"£45,000 incl. VAT 20%", Price{Amount: 45000, Currency: "GBP", VATIncluded: true, VATRate: 20}},
+		{"1.234,56 €", Price{Amount: 1234.56, Currency: "EUR"}},
+		{"12,5 €", Price{Amount: 12.5, Currency: "EUR"}},
+		{"CHF 12'500.50", Price{Amount: 12500.5, Currency: "CHF"}},
+		{"1 250 000 NOK", Price{Amount: 1250000, Currency: "NOK"}},
+		{"US$ 5,000", Price{Amount: 5000, Currency: "USD"}},
+		{"Fr. 8.900", Price{Amount: 8900, Currency: "CHF"}},
+		// An ISO code wins over a symbol for another currency.
+		{"EUR 1.000 (£ 850)", Price{Amount: 1000, Currency: "EUR"}},
+		{"POA", Price{}},
+		{"Preis auf Anfrage", Price{}},
+		{"Price on request", Price{}},
+		{"  ", Price{}},
+	}
+	for _, tt := range tests {
+		got, err := parsePrice(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parsePrice(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"Call for price", "€", "Verhandlungsbasis"} {
+		var pe *PriceError
+		if _, err := parsePrice(bad); !errors.As(err, &pe) {
+			t.Errorf("parsePrice(%q) error = %v, want a *PriceError", bad, err)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"116.904", 116904},
+		{"36,950", 36950},
+		{"1.000.000", 1000000},
+		{"1,000,000.25", 1000000.25},
+		{"1.000.000,25", 1000000.25},
+		{"12,5", 12.5},
+		{"1.5", 1.5},
+		{"12'500", 12500},
+		{"250 000", 250000},
+	}
+	for _, tt := range tests {
+		if got, ok := parseAmount(tt.in); !ok || got != tt.want {
+			t.Errorf("parseAmount(%q) = %v, %v; want %v", tt.in, got, ok, tt.want)
+		}
+	}
+	if _, ok := parseAmount(""); ok {
+		t.Error("parseAmount accepted an empty string")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// politeness is how hard a source may be crawled. Sources declare their own
+// defaults and the scrape flags can override them.
+type politeness struct {
+	Workers           int           // concurrent detail-page fetches
+	RequestsPerSecond float64       // sustained request rate per host
+	Burst             int           // requests allowed back to back
+	Jitter            time.Duration // random extra wait added to every request
+}
+
+// tokenBucket is a token-bucket rate limiter: it holds up to burst tokens,
+// refilled at rate per second, and each request takes one.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it. The token is taken even if the wait is not yet over, so
+// concurrent callers queue up behind each other.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// hostLimiter rate-limits requests separately for each host.
+type hostLimiter struct {
+	policy politeness
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newHostLimiter(p politeness) *hostLimiter {
+	return &hostLimiter{policy: p, buckets: make(map[string]*tokenBucket)}
+}
+
+// wait blocks until a request to rawURL is allowed, or ctx is done.
+func (l *hostLimiter) wait(ctx context.Context, rawURL string) error {
+	d := l.jitter()
+	if l.policy.RequestsPerSecond > 0 {
+		d += l.bucket(rawURL).reserve()
+	}
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (l *hostLimiter) jitter() time.Duration {
+	if l.policy.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(l.policy.Jitter)))
+}
+
+func (l *hostLimiter) bucket(rawURL string) *tokenBucket {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[host]
+	if !ok {
+		b = newTokenBucket(l.policy.RequestsPerSecond, l.policy.Burst)
+		l.buckets[host] = b
+	}
+	return b
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 3)
+	for i := 0; i < 3; i++ {
+		if d := b.reserve(); d != 0 {
+			t.Errorf("request %d within the burst waits %v", i+1, d)
+		}
+	}
+	// At 2 a second each further request queues half a second behind the
+	// one before, less the little refilled while the test runs.
+	for i, want := range []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond} {
+		if d := b.reserve(); d > want || d < want-50*time.Millisecond {
+			t.Errorf("request %d beyond the burst waits %v, want %v", i+1, d, want)
+		}
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	b := newTokenBucket(2, 3)
+	b.tokens = -1
+	b.last = time.Now().Add(-time.Second)
+	if d := b.reserve(); d != 0 {
+		t.Errorf("after a second's refill the request waits %v", d)
+	}
+
+	// A long idle spell refills no more than the burst.
+	b.last = time.Now().Add(-time.Hour)
+	b.reserve()
+	if b.tokens > 2 {
+		t.Errorf("tokens after an idle hour = %v, want at most burst-1 = 2", b.tokens)
+	}
+}
+
+func TestTokenBucketMinimumBurst(t *testing.T) {
+	b := newTokenBucket(1, 0)
+	if b.burst != 1 {
+		t.Errorf("burst 0 gives a bucket of %v, want 1", b.burst)
+	}
+	if d := b.reserve(); d != 0 {
+		t.Errorf("first request waits %v", d)
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(politeness{RequestsPerSecond: 1, Burst: 1})
+	ctx := context.Background()
+	if err := l.wait(ctx, "https://a.example/1"); err != nil {
+		t.Fatal(err)
+	}
+	// Another host has a bucket of its own.
+	if err := l.wait(ctx, "https://b.example/1"); err != nil {
+		t.Fatal(err)
+	}
+	if l.bucket("https://a.example/2") != l.bucket("https://a.example/3") {
+		t.Error("requests to the same host use different buckets")
+	}
+
+	// The next request to a.example would wait a second: a cancelled
+	// context ends the wait at once.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	start := time.Now()
+	if err := l.wait(ctx, "https://a.example/2"); err != context.Canceled {
+		t.Errorf("wait with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("cancelled wait took %v", d)
+	}
+}
+
+func TestHostLimiterUnlimited(t *testing.T) {
+	l := newHostLimiter(politeness{})
+	for i := 0; i < 100; i++ {
+		if err := l.wait(context.Background(), "https://a.example/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("an unlimited policy made %d buckets", len(l.buckets))
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateBase is the currency every rate in a rates file is quoted against.
+const rateBase = "EUR"
+
+// dailyRate is the number of units of a currency worth one EUR on a date.
+type dailyRate struct {
+	Date time.Time
+	Rate float64
+}
+
+// rateTable converts between currencies using locally stored, dated rates
+// so that runs are reproducible and need no network access.
+type rateTable struct {
+	rates map[string][]dailyRate // sorted by date
+}
+
+//go:embed rates.csv
+var builtinRates []byte
+
+// rateEntry is one dated rate as a rates file gives it.
+type rateEntry struct {
+	Date     string  `json:"date"`
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`
+}
+
+// loadRates reads the built-in rates and then each file in extra, whose
+// rates are added to the built-in ones and win over them for the same
+// currency and date. CSV files have the columns date,currency,rate; JSON
+// files hold an array of {"date": "2024-09-18", "currency": "GBP",
+// "rate": 0.84}. Rates are units of currency per one EUR.
+func loadRates(extra ...string) (*rateTable, error) {
+	t := &rateTable{rates: make(map[string][]dailyRate)}
+	if err := t.read(bytes.NewReader(builtinRates), false); err != nil {
+		return nil, fmt.Errorf("error in built-in rates: %w", err)
+	}
+	for _, path := range extra {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening rates file: %w", err)
+		}
+		err = t.read(f, strings.EqualFold(filepath.Ext(path), ".json"))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading rates file %s: %w", path, err)
+		}
+	}
+	for _, rs := range t.rates {
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].Date.Before(rs[j].Date) })
+	}
+	return t, nil
+}
+
+// read adds the rates of a CSV or, if isJSON, JSON rates file.
+func (t *rateTable) read(r io.Reader, isJSON bool) error {
+	var entries []rateEntry
+	if isJSON {
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return err
+		}
+	} else {
+		cr := csv.NewReader(r)
+		cr.Comment = '#'
+		cr.FieldsPerRecord = 3
+		for line := 1; ; line++ {
+			record, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if line == 1 && record[0] == "date" {
+				continue
+			}
+			rate, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+			if err != nil {
+				return fmt.Errorf("line %d: bad rate %q", line, record[2])
+			}
+			entries = append(entries, rateEntry{Date: record[0], Currency: record[1], Rate: rate})
+		}
+	}
+
+	for _, e := range entries {
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(e.Date))
+		if err != nil {
+			return fmt.Errorf("bad date %q", e.Date)
+		}
+		if e.Rate <= 0 {
+			return fmt.Errorf("rate for %s on %s must be positive", e.Currency, e.Date)
+		}
+		code := strings.ToUpper(strings.TrimSpace(e.Currency))
+		t.rates[code] = append(t.rates[code], dailyRate{Date: date, Rate: e.Rate})
+	}
+	return nil
+}
+
+// rate returns the units of currency per EUR in effect on date: the latest
+// rate on or before it (the last one given, for a date with several), or
+// the earliest known rate for dates before the file starts.
+func (t *rateTable) rate(currency string, date time.Time) (float64, bool) {
+	if currency == rateBase {
+		return 1, true
+	}
+	rs := t.rates[currency]
+	if len(rs) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].Date.After(date) })
+	if i == 0 {
+		return rs[0].Rate, true
+	}
+	return rs[i-1].Rate, true
+}
+
+// convert changes amount from one currency to another at the rates in
+// effect on date.
+func (t *rateTable) convert(amount float64, from, to string, date time.Time) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, ok := t.rate(from, date)
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate for %s", from)
+	}
+	toRate, ok := t.rate(to, date)
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate for %s", to)
+	}
+	return math.Round(amount/fromRate*toRate*100) / 100, nil
+}
+
+// convertListings fills in each listing's price in the reporting currency.
+// Listings without a price, or whose currency has no rate, are left
+// unconverted and logged.
+func convertListings(t *rateTable, currency string, listings []Listing) {
+	for i := range listings {
+		l := &listings[i]
+		if l.Price.Amount == 0 || l.Price.Currency == "" {
+			continue
+		}
+		converted, err := t.convert(l.Price.Amount, l.Price.Currency, currency, l.ScrapedAt)
+		if err != nil {
+			log.Printf("%s listing %s: %v", l.Source, l.ID, err)
+			continue
+		}
+		l.PriceInReportingCurrency = converted
+		l.ReportingCurrency = currency
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustDate parses a test date as 2006-01-02.
+func mustDate(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestRateTableConvert(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "rates.csv")
+	jsonFile := filepath.Join(dir, "rates.json")
+	os.WriteFile(csvFile, []byte("date,currency,rate\n2024-09-18,GBP,0.9000\n2024-10-01,gbp,0.8500\n"), 0o644)
+	os.WriteFile(jsonFile, []byte(`[{"date": "2024-10-01", "currency": "USD", "rate": 1.10}]`), 0o644)
+
+	builtin, err := loadRates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := loadRates(csvFile, jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		table    *rateTable
+		amount   float64
+		from, to string
+		on       string
+		want     float64
+	}{
+		{builtin, 1000, "EUR", "GBP", "2024-09-20", 843},
+		{builtin, 843, "GBP", "NOK", "2024-09-20", 11795},
+		// Dates before the first rate use it.
+		{builtin, 1000, "EUR", "GBP", "2020-01-01", 843},
+		{builtin, 12.5, "SEK", "SEK", "2024-09-20", 12.5},
+		// A file's rate for the same date wins over the built-in one.
+		{rates, 1000, "EUR", "GBP", "2024-09-20", 900},
+		{rates, 1000, "EUR", "GBP", "2024-10-02", 850},
+		{rates, 850, "GBP", "USD", "2024-10-02", 1100},
+	}
+	for _, tt := range tests {
+		got, err := tt.table.convert(tt.amount, tt.from, tt.to, mustDate(tt.on))
+		if err != nil || got != tt.want {
+			t.Errorf("convert(%v %s to %s on %s) = %v, %v; want %v", tt.amount, tt.from, tt.to, tt.on, got, err, tt.want)
+		}
+	}
+	if _, err := builtin.convert(100, "XYZ", "EUR", mustDate("2024-09-20")); err == nil {
+		t.Error("converted from a currency without rates")
+	}
+}
+
+func TestLoadRatesErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"negative.csv": "2024-09-18,GBP,-1\n",
+		"date.csv":     "18.09.2024,GBP,0.84\n",
+		"columns.csv":  "2024-09-18,GBP\n",
+		"bad.json":     `{"date": "2024-09-18"}`,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := loadRates(path); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: error = %v, want one naming the file", name, err)
+		}
+	}
+	if _, err := loadRates(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
+
+func TestConvertListings(t *testing.T) {
+	rates, err := loadRates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := mustDate("2024-09-20")
+	listings := []Listing{
+		{ID: "1", Price: Price{Amount: 1000, Currency: "EUR"}, ScrapedAt: at},
+		{ID: "2", Price: Price{Amount: 1000}, ScrapedAt: at},
+		{ID: "3", Price: Price{Amount: 1000, Currency: "XYZ"}, ScrapedAt: at},
+		{ID: "4", ScrapedAt: at},
+	}
+	convertListings(rates, "GBP", listings)
+	if l := listings[0]; l.PriceInReportingCurrency != 843 || l.ReportingCurrency != "GBP" {
+		t.Errorf("EUR listing converted to %v %s", l.PriceInReportingCurrency, l.ReportingCurrency)
+	}
+	for _, l := range listings[1:] {
+		if l.PriceInReportingCurrency != 0 || l.ReportingCurrency != "" {
+			t.Errorf("listing %s converted to %v %s", l.ID, l.PriceInReportingCurrency, l.ReportingCurrency)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// searchFilter is a search common to every source. Zero values match
+// everything. Prices are in each listing's own currency.
+type searchFilter struct {
+	Make, Model        string
+	MinYear, MaxYear   int
+	MinHP, MaxHP       int
+	MinPrice, MaxPrice float64
+	Country            string // ISO code
+	Category           string // one of searchCategories; "" means tractors
+
+	catalog *modelCatalog // set by useCatalog if it knows Make
+}
+
+// searchCategories are the categories a filter can name. Each site maps
+// them to its own in the search section of its definition.
+var searchCategories = []string{"tractors", "compact-tractors", "vintage-tractors"}
+
+// searchParams are the criteria a site definition can pass as query
+// parameters.
+var searchParams = []string{
+	"make", "model", "min_year", "max_year", "min_hp", "max_hp",
+	"min_price", "max_price", "country",
+}
+
+// addFlags defines the scrape flags that fill in f.
+func (f *searchFilter) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.Make, "make", "", "only search for this make, e.g. Fordson")
+	fs.StringVar(&f.Model, "model", "", "only search for this model, e.g. Major (with -make)")
+	fs.IntVar(&f.MinYear, "min-year", 0, "earliest year of construction")
+	fs.IntVar(&f.MaxYear, "max-year", 0, "latest year of construction")
+	fs.IntVar(&f.MinHP, "min-hp", 0, "lowest power in hp")
+	fs.IntVar(&f.MaxHP, "max-hp", 0, "highest power in hp")
+	fs.Float64Var(&f.MinPrice, "min-price", 0, "lowest asking price, in the advert's currency")
+	fs.Float64Var(&f.MaxPrice, "max-price", 0, "highest asking price, in the advert's currency")
+	fs.StringVar(&f.Country, "country", "", "only search in this country, e.g. AT or Austria")
+	fs.StringVar(&f.Category, "category", "", "machine category: "+strings.Join(searchCategories, ", ")+" (default tractors)")
+}
+
+// validate checks the filter and normalises its country to an ISO code.
+func (f *searchFilter) validate() error {
+	if f.Category != "" && !slices.Contains(searchCategories, f.Category) {
+		return fmt.Errorf("unknown -category %q (available: %s)", f.Category, strings.Join(searchCategories, ", "))
+	}
+	if f.Model != "" && f.Make == "" {
+		return fmt.Errorf("-model needs -make")
+	}
+	if f.Country != "" {
+		code := countryCode(f.Country)
+		if code == "" {
+			return fmt.Errorf("unknown -country %q", f.Country)
+		}
+		f.Country = code
+	}
+	if f.MaxYear > 0 && f.MinYear > f.MaxYear || f.MaxHP > 0 && f.MinHP > f.MaxHP ||
+		f.MaxPrice > 0 && f.MinPrice > f.MaxPrice {
+		return fmt.Errorf("a minimum is above its maximum")
+	}
+	return nil
+}
+
+// useCatalog replaces the filter's make with the catalog's name for it, so
+// that "-make MF" searches for Massey Ferguson, and has matches compare
+// makes by the catalog's names and aliases.
+func (f *searchFilter) useCatalog(c *modelCatalog) {
+	if f.Make == "" {
+		return
+	}
+	if mk, _ := c.findMake(titleWords(f.Make)); mk != nil {
+		f.Make, f.catalog = mk.Name, c
+	}
+}
+
+func (f searchFilter) isZero() bool {
+	return f == searchFilter{}
+}
+
+// params returns the filter's criteria that are set, by searchParams name.
+func (f searchFilter) params() map[string]string {
+	p := make(map[string]string)
+	set := func(name, v string) {
+		if v != "" && v != "0" {
+			p[name] = v
+		}
+	}
+	set("make", f.Make)
+	set("model", f.Model)
+	set("min_year", strconv.Itoa(f.MinYear))
+	set("max_year", strconv.Itoa(f.MaxYear))
+	set("min_hp", strconv.Itoa(f.MinHP))
+	set("max_hp", strconv.Itoa(f.MaxHP))
+	set("min_price", formatAmount(f.MinPrice))
+	set("max_price", formatAmount(f.MaxPrice))
+	set("country", f.Country)
+	return p
+}
+
+// buildSearchURL builds the results page URL for f from the search section of
+// the source's definition. It also returns the criteria the site cannot
+// search by; the crawl applies every criterion to the results itself, but
+// for those it has to fetch all the pages.
+func buildSearchURL(src Source, f searchFilter) (string, []string, error) {
+	if f.isZero() {
+		return src.DefaultURL(), nil, nil
+	}
+	s := site(src.Name()).Search
+	category := f.Category
+	if category == "" {
+		category = "tractors"
+	}
+	slug, ok := s.Categories[category]
+	if !ok {
+		return "", nil, fmt.Errorf("%s has no %s category", src.Name(), category)
+	}
+
+	params := f.params()
+	template := s.URL
+	makeName, modelName := strings.ToLower(f.Make), strings.ToLower(f.Model)
+	switch page := s.Pages; {
+	case category == "tractors" && modelName != "" && page[makeName+" "+modelName] != "":
+		template = page[makeName+" "+modelName]
+		delete(params, "make")
+		delete(params, "model")
+	case category == "tractors" && makeName != "" && page[makeName] != "":
+		template = page[makeName]
+		delete(params, "make")
+	case makeName != "" && s.MakeURL != "":
+		template = s.MakeURL
+		delete(params, "make")
+	}
+	if template == "" {
+		return "", nil, fmt.Errorf("the %s site definition has no search url", src.Name())
+	}
+	u, err := url.Parse(strings.NewReplacer(
+		"{category}", slug,
+		"{make}", strings.Join(strings.Fields(f.Make), "-"),
+	).Replace(template))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid %s search url: %w", src.Name(), err)
+	}
+
+	query := u.Query()
+	var local []string
+	for _, name := range searchParams {
+		v, ok := params[name]
+		if !ok {
+			continue
+		}
+		if key := s.Params[name]; key != "" {
+			query.Set(key, v)
+		} else {
+			local = append(local, name)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), local, nil
+}
+
+// matches reports whether l may meet the filter. Values the listing does
+// not have, such as the year of an advert whose detail page has not been
+// read, are given the benefit of the doubt.
+func (f searchFilter) matches(l *Listing) bool {
+	between := func(v, min, max float64) bool {
+		return v == 0 || (min == 0 || v >= min) && (max == 0 || v <= max)
+	}
+	has := func(field, want string) bool {
+		want = strings.ToLower(want)
+		return want == "" || strings.Contains(strings.ToLower(field), want) ||
+			field == "" && strings.Contains(strings.ToLower(l.Title), want)
+	}
+	hasMake := has(l.Make, f.Make)
+	if f.catalog != nil {
+		// "Ford" must not match "Fordson" once the catalog knows both.
+		if mk := f.catalog.match(l).Make; mk != "" {
+			hasMake = mk == f.Make
+		}
+	}
+	return hasMake && has(l.Model, f.Model) &&
+		between(float64(l.Year), float64(f.MinYear), float64(f.MaxYear)) &&
+		between(float64(l.PowerHP), float64(f.MinHP), float64(f.MaxHP)) &&
+		between(l.Price.Amount, f.MinPrice, f.MaxPrice) &&
+		(f.Country == "" || l.Place.Country == "" || l.Place.Country == f.Country)
+}
+
+// withQuery returns rawURL with the query parameter key set to value.
+func withQuery(rawURL, key, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
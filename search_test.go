@@ -0,0 +1,124 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildSearchURL(t *testing.T) {
+	tests := []struct {
+		source    string
+		filter    searchFilter
+		want      string
+		wantLocal []string
+	}{
+		{"landwirt", searchFilter{}, landwirtOrigin + "/en/used-farm-machinery/tractors.html", nil},
+		{"landwirt", searchFilter{Make: "Fordson", MinYear: 1955},
+			landwirtOrigin + "/en/used-farm-machinery/used-Fordson-tractors.html?year_from=1955", nil},
+		{"landwirt", searchFilter{Make: "John Deere", MaxHP: 100, Country: "AT"},
+			landwirtOrigin + "/en/used-farm-machinery/used-John-Deere-tractors.html?country=AT&hp_to=100", nil},
+		{"agriaffaires", searchFilter{Make: "FORDSON", Model: "Major", MaxPrice: 8000},
+			agriaffairesOrigin + "/used/farm-tractor/1/16730/fordson-major.html?price_max=8000", nil},
+		// Fordson has no page of its own, so the whole category is read.
+		{"agriaffaires", searchFilter{Make: "Fordson", MinYear: 1955, Country: "GB"},
+			agriaffairesOrigin + "/used/farm-tractor.html?year_min=1955", []string{"make", "country"}},
+		{"agriaffaires", searchFilter{Category: "vintage-tractors"}, agriaffairesOrigin + "/used/vintage-tractor.html", nil},
+	}
+	for _, tt := range tests {
+		src, _ := lookupSource(tt.source)
+		got, local, err := buildSearchURL(src, tt.filter)
+		if err != nil || got != tt.want || !slices.Equal(local, tt.wantLocal) {
+			t.Errorf("%s %+v:\n got %s %v %v\nwant %s %v", tt.source, tt.filter, got, local, err, tt.want, tt.wantLocal)
+		}
+	}
+}
+
+func TestListingURLKeepsQuery(t *testing.T) {
+	base := landwirtOrigin + "/en/used-farm-machinery/used-Fordson-tractors.html?year_from=1955"
+	want := landwirtOrigin + "/en/used-farm-machinery/used-Fordson-tractors.html?offset=20&year_from=1955"
+	if got := (landwirt{}).ListingURL(base, 2); got != want {
+		t.Errorf("ListingURL = %s, want %s", got, want)
+	}
+}
+
+func TestSearchFilterMatches(t *testing.T) {
+	listings := append(fixtureListings(t, "landwirt"), fixtureListings(t, "agriaffaires")...)
+	ids := func(f searchFilter) []string {
+		var ids []string
+		for _, l := range listings {
+			if f.matches(&l) {
+				ids = append(ids, l.ID)
+			}
+		}
+		return ids
+	}
+
+	// Make is matched against the title where the results page gives no
+	// make of its own.
+	if got := ids(searchFilter{Make: "fordson"}); !slices.Equal(got, []string{"4491022", "44698339"}) {
+		t.Errorf("fordson: %v", got)
+	}
+	// agriaffaires results pages have no year, so their adverts are kept
+	// until the detail pages say otherwise.
+	if got := ids(searchFilter{MinYear: 2010}); !slices.Equal(got, []string{"4483344", "45219407", "44582981", "44698339"}) {
+		t.Errorf("from 2010: %v", got)
+	}
+	if got := ids(searchFilter{MaxPrice: 40000, Country: "GB"}); !slices.Equal(got, []string{"4483344", "4491022", "45219407", "44698339"}) {
+		t.Errorf("under 40000 in GB: %v", got)
+	}
+}
+
+// TestSearchFilterCatalog searches by a make's alias, which must find the
+// make under its proper name but not a make it is part of.
+func TestSearchFilterCatalog(t *testing.T) {
+	catalog, err := loadModelCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := searchFilter{Make: "MF"}
+	f.useCatalog(catalog)
+	if f.Make != "Massey Ferguson" {
+		t.Errorf("-make MF is %q", f.Make)
+	}
+	src, _ := lookupSource("landwirt")
+	if got, _, _ := buildSearchURL(src, f); got != landwirtOrigin+"/en/used-farm-machinery/used-Massey-Ferguson-tractors.html" {
+		t.Errorf("-make MF searches %s", got)
+	}
+
+	tests := []struct {
+		make string
+		l    Listing
+		want bool
+	}{
+		{"MF", Listing{Make: "Massey Ferguson", Title: "Massey Ferguson 135"}, true},
+		{"MF", Listing{Title: "Massey-Ferguson 135 Multipower"}, true},
+		{"massey", Listing{Make: "MF", Title: "MF 35X"}, true},
+		{"MF", Listing{Make: "Fordson", Title: "Fordson Major"}, false},
+		{"Ford", Listing{Make: "Fordson", Title: "Fordson Major"}, false},
+		{"Ford", Listing{Make: "Ford", Title: "Ford 5000"}, true},
+		// Makes the catalog does not know are matched by name.
+		{"Lamborghini", Listing{Make: "Lamborghini Trattori", Title: "R 235"}, true},
+		{"Lamborghini", Listing{Make: "Fiat", Title: "Fiat 480"}, false},
+	}
+	for _, tt := range tests {
+		f := searchFilter{Make: tt.make}
+		f.useCatalog(catalog)
+		if got := f.matches(&tt.l); got != tt.want {
+			t.Errorf("-make %s matches %+v = %v, want %v", tt.make, tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestSearchFilterValidate(t *testing.T) {
+	f := searchFilter{Country: "Austria"}
+	if err := f.validate(); err != nil || f.Country != "AT" {
+		t.Errorf("validate = %v, country %q", err, f.Country)
+	}
+	for _, bad := range []searchFilter{
+		{Category: "combines"}, {Model: "Major"}, {Country: "Atlantis"}, {MinYear: 1970, MaxYear: 1960},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// siteDefinition holds the selectors used to read one site's pages. The
+// built-in definitions live in sites/ and are compiled in; a file for the
+// same source in the scrape -sites directory replaces one, so a field
+// broken by a site redesign can be fixed without recompiling.
+type siteDefinition struct {
+	Search  searchSpec     `yaml:"search"`
+	Listing pageDefinition `yaml:"listing"`
+	Detail  pageDefinition `yaml:"detail"`
+}
+
+// searchSpec describes how the site's results pages encode a searchFilter.
+// Criteria with no way of being encoded are applied to the results instead.
+type searchSpec struct {
+	// URL is the results page of a category, with {category} standing for
+	// the site's name for it.
+	URL string `yaml:"url"`
+	// MakeURL, if set, is used instead of URL when a make is given, with
+	// {make} standing for the make.
+	MakeURL string `yaml:"make_url"`
+	// Pages maps a lowercase "make" or "make model" to the tractors results
+	// page for it, for sites that number their makes.
+	Pages map[string]string `yaml:"pages"`
+	// Categories maps each of searchCategories the site has to its name on
+	// the site.
+	Categories map[string]string `yaml:"categories"`
+	// Params maps criteria (see searchParams) to query parameter names.
+	Params map[string]string `yaml:"params"`
+}
+
+// pageDefinition describes one kind of page.
+type pageDefinition struct {
+	// Item matches each advert on a results page. Fields are read relative
+	// to it; on a detail page they are read from the whole document.
+	Item selectorList `yaml:"item"`
+	// Fields maps a field name (see listingFields, featureFields and
+	// priceFields) to where its value is on the page.
+	Fields map[string]fieldSpec `yaml:"fields"`
+	// Attributes reads a key/value specification table.
+	Attributes *tableSpec `yaml:"attributes"`
+	// Equipment reads a list of features.
+	Equipment *listSpec `yaml:"equipment"`
+	// Contacts reads the dealer's phone numbers.
+	Contacts *contactSpec `yaml:"contacts"`
+	// Next matches the link to the following results page, for sites
+	// without a rel="next" link.
+	Next selectorList `yaml:"next"`
+	// MinFill is the lowest acceptable share, from 0 to 1, of a crawl's
+	// listings that have each field (see reportFields) filled from this
+	// page. A lower fill rate usually means the site's layout has changed.
+	MinFill map[string]float64 `yaml:"min_fill"`
+}
+
+// fieldSpec locates one value. Each selector is tried in turn and the first
+// that gives a non-empty value wins; an empty selector means the element
+// the field is read from. The value is the element's text (see text), or
+// an attribute of it, with surrounding whitespace and any Trim characters
+// removed, then decoded if Decode is set. If Regex is set the value becomes
+// its first capture group (or whole match), or empty if it does not match.
+type fieldSpec struct {
+	Selector selectorList `yaml:"selector"`
+	Attr     string       `yaml:"attr"`
+	Last     bool         `yaml:"last"` // use the last match rather than the first
+	Trim     string       `yaml:"trim"`
+	Decode   decoding     `yaml:"decode"`
+	Regex    *pattern     `yaml:"regex"`
+}
+
+// tableSpec reads rows of key/value pairs into Listing.Attributes, and
+// copies the values of the keys in Fields into listing fields.
+type tableSpec struct {
+	Rows  selectorList `yaml:"rows"`
+	Key   fieldSpec    `yaml:"key"`
+	Value fieldSpec    `yaml:"value"`
+	// Fields maps a row key, ignoring any trailing colon, to a listing
+	// field.
+	Fields map[string]string `yaml:"fields"`
+}
+
+// listSpec reads one value from each element Items matches into
+// Listing.Equipment.
+type listSpec struct {
+	Items selectorList `yaml:"items"`
+	Value fieldSpec    `yaml:"value"`
+	// Fields maps an item, or the part of one before a colon as in "Top
+	// speed in km/h: 40 km/h", to a feature field. The field is set to the
+	// part after the colon, or else to the item itself.
+	Fields map[string]string `yaml:"fields"`
+}
+
+// contactSpec reads one phone number from each element Items matches.
+// Label is the text saying what kind of number it is ("Mobile", "Fax");
+// numbers without one are phones.
+type contactSpec struct {
+	Items  selectorList `yaml:"items"`
+	Number fieldSpec    `yaml:"number"`
+	Label  fieldSpec    `yaml:"label"`
+}
+
+// decoding names a way values are obfuscated on a site.
+type decoding string
+
+// decodings are the supported decode values.
+var decodings = map[string]func(string) string{
+	"prefixed-base64": decodePrefixedBase64,
+}
+
+func (d *decoding) UnmarshalYAML(value *yaml.Node) error {
+	if _, ok := decodings[value.Value]; !ok {
+		return fmt.Errorf("line %d: unknown decode %q (want one of: %s)",
+			value.Line, value.Value, strings.Join(sortedKeys(decodings), ", "))
+	}
+	*d = decoding(value.Value)
+	return nil
+}
+
+// selectorList is a list of CSS selectors that may be written as a single
+// string.
+type selectorList []string
+
+func (l *selectorList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = selectorList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// pattern is a regular expression compiled when the definition is loaded.
+type pattern struct {
+	*regexp.Regexp
+}
+
+func (p *pattern) UnmarshalYAML(value *yaml.Node) error {
+	re, err := regexp.Compile(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	p.Regexp = re
+	return nil
+}
+
+// listingFields are the field names setFields copies into a Listing.
+var listingFields = []string{
+	"title", "image", "make", "model", "year", "hours", "power", "condition",
+	"dealer", "dealer_address", "dealer_postcode", "dealer_country",
+	"location", "description",
+}
+
+// priceFields are the field names a source reads itself: the links, which
+// are relative to the site, and the parts of the price.
+var priceFields = []string{
+	"url", "dealer_url", "price", "net_price", "original_price", "currency", "vat",
+	"reference_price", "reference_currency",
+}
+
+// siteDefinitions holds the definition of each source by name.
+var siteDefinitions = mustLoadBuiltinSites()
+
+//go:embed sites/*.yaml
+var builtinSites embed.FS
+
+func mustLoadBuiltinSites() map[string]*siteDefinition {
+	defs := make(map[string]*siteDefinition)
+	files, _ := fs.Glob(builtinSites, "sites/*.yaml")
+	for _, file := range files {
+		data, _ := builtinSites.ReadFile(file)
+		def, err := parseSiteDefinition(data)
+		if err != nil {
+			panic(fmt.Sprintf("built-in %s: %v", file, err))
+		}
+		defs[strings.TrimSuffix(filepath.Base(file), ".yaml")] = def
+	}
+	return defs
+}
+
+// loadSiteDefinitions replaces the built-in definition of every source that
+// has a <name>.yaml, <name>.yml or <name>.json file in dir.
+func loadSiteDefinitions(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("error reading site definitions: %w", err)
+	}
+	for _, name := range sourceNames() {
+		for _, ext := range []string{".yaml", ".yml", ".json"} {
+			path := filepath.Join(dir, name+ext)
+			data, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error reading site definition: %w", err)
+			}
+			def, err := parseSiteDefinition(data)
+			if err != nil {
+				return fmt.Errorf("error in site definition %s: %w", path, err)
+			}
+			log.Printf("Using site definition %s", path)
+			siteDefinitions[name] = def
+			break
+		}
+	}
+	return nil
+}
+
+// parseSiteDefinition reads a definition written in YAML or JSON (which is
+// also YAML). Unknown keys and field names are errors so that typos do not
+// silently leave a field empty.
+func parseSiteDefinition(data []byte) (*siteDefinition, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var def siteDefinition
+	if err := dec.Decode(&def); err != nil {
+		return nil, err
+	}
+	if len(def.Listing.Item) == 0 {
+		return nil, fmt.Errorf("listing.item is required")
+	}
+	for name := range def.Search.Categories {
+		if !slices.Contains(searchCategories, name) {
+			return nil, fmt.Errorf("search.categories: unknown category %q", name)
+		}
+	}
+	for name := range def.Search.Params {
+		if !slices.Contains(searchParams, name) {
+			return nil, fmt.Errorf("search.params: unknown criterion %q", name)
+		}
+	}
+	for page, p := range map[string]pageDefinition{"listing": def.Listing, "detail": def.Detail} {
+		for name := range p.Fields {
+			if !slices.Contains(listingFields, name) && !slices.Contains(featureFields, name) && !slices.Contains(priceFields, name) {
+				return nil, fmt.Errorf("%s.fields: unknown field %q", page, name)
+			}
+		}
+		if p.Attributes != nil {
+			for key, name := range p.Attributes.Fields {
+				if !slices.Contains(listingFields, name) && !slices.Contains(featureFields, name) {
+					return nil, fmt.Errorf("%s.attributes.fields: %q maps to unknown field %q", page, key, name)
+				}
+			}
+		}
+		if p.Equipment != nil {
+			for item, name := range p.Equipment.Fields {
+				if !slices.Contains(featureFields, name) {
+					return nil, fmt.Errorf("%s.equipment.fields: %q maps to unknown feature %q", page, item, name)
+				}
+			}
+		}
+		for name, min := range p.MinFill {
+			if !slices.Contains(reportFields, name) {
+				return nil, fmt.Errorf("%s.min_fill: unknown field %q", page, name)
+			}
+			if min < 0 || min > 1 {
+				return nil, fmt.Errorf("%s.min_fill: %s must be between 0 and 1", page, name)
+			}
+		}
+	}
+	return &def, nil
+}
+
+// site returns the definition in use for a source.
+func site(name string) *siteDefinition {
+	return siteDefinitions[name]
+}
+
+// items returns the elements matched by the first Item selector that
+// matches anything.
+func (p *pageDefinition) items(doc *goquery.Document) *goquery.Selection {
+	for _, sel := range p.Item {
+		if items := doc.Find(sel); items.Length() > 0 {
+			return items
+		}
+	}
+	return doc.Selection.Slice(0, 0)
+}
+
+// nextURL returns the address of the following results page: the page's
+// rel="next" link if it has one, or else the href of the first Next
+// selector that matches. It is "" on the last page.
+func (p *pageDefinition) nextURL(doc *goquery.Document) string {
+	for _, sel := range append(selectorList{`link[rel="next"]`, `a[rel="next"]`}, p.Next...) {
+		href := strings.TrimSpace(doc.Find(sel).First().AttrOr("href", ""))
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+			continue
+		}
+		if next := resolveURL(doc, href); next != "" {
+			return next
+		}
+	}
+	return ""
+}
+
+// read fills listing from the page fields, table and list found in s, and
+// returns the raw field values for the caller to read the rest from.
+func (p *pageDefinition) read(s *goquery.Selection, listing *Listing) map[string]string {
+	values := make(map[string]string, len(p.Fields))
+	for name, f := range p.Fields {
+		values[name] = f.value(s)
+	}
+	setFields(listing, values)
+
+	if t := p.Attributes; t != nil {
+		if listing.Attributes == nil {
+			listing.Attributes = make(map[string]string)
+		}
+		for _, sel := range t.Rows {
+			s.Find(sel).Each(func(i int, row *goquery.Selection) {
+				key, value := t.Key.value(row), t.Value.value(row)
+				if key == "" || value == "" {
+					return
+				}
+				listing.Attributes[key] = value
+				if name, ok := t.Fields[strings.TrimSpace(strings.TrimSuffix(key, ":"))]; ok {
+					setFields(listing, map[string]string{name: value})
+				}
+			})
+		}
+	}
+
+	if l := p.Equipment; l != nil {
+		listing.Equipment = nil
+		for _, sel := range l.Items {
+			s.Find(sel).Each(func(i int, item *goquery.Selection) {
+				v := l.Value.value(item)
+				if v == "" {
+					return
+				}
+				listing.Equipment = append(listing.Equipment, v)
+				name, value, ok := strings.Cut(v, ":")
+				if !ok {
+					value = v
+				}
+				if field, ok := l.Fields[strings.TrimSpace(name)]; ok {
+					listing.Features.set(field, strings.TrimSpace(value))
+				}
+			})
+		}
+	}
+	if c := p.Contacts; c != nil {
+		listing.Dealer.Contacts = nil
+		seen := make(map[string]bool)
+		for _, sel := range c.Items {
+			s.Find(sel).Each(func(i int, item *goquery.Selection) {
+				contact, ok := parseContact(c.Number.value(item), c.Label.value(item), listing.Dealer.Country)
+				key := contact.Number
+				if key == "" {
+					key = contact.Raw
+				}
+				if ok && !seen[key] {
+					seen[key] = true
+					listing.Dealer.Contacts = append(listing.Dealer.Contacts, contact)
+				}
+			})
+		}
+	}
+	listing.Features.scan(strings.Join(append([]string{listing.Title, listing.Description}, listing.Equipment...), "\n"))
+	return values
+}
+
+// setFields copies the non-empty listingFields and featureFields in values
+// into listing.
+func setFields(listing *Listing, values map[string]string) {
+	for _, name := range listingFields {
+		v := values[name]
+		if v == "" {
+			continue
+		}
+		switch name {
+		case "title":
+			listing.Title = v
+		case "image":
+			listing.ImageURL = v
+		case "make":
+			listing.Make = v
+		case "model":
+			listing.Model = v
+		case "year":
+			listing.Year = parseYear(v)
+		case "hours":
+			listing.Hours = parseInt(v)
+		case "power":
+			listing.PowerHP, listing.PowerKW = parsePower(v)
+		case "condition":
+			listing.Condition = v
+		case "dealer":
+			listing.Dealer.Name = v
+		case "dealer_address":
+			listing.Dealer.Address = joinLines(v)
+		case "dealer_postcode":
+			listing.Dealer.Postcode = v
+		case "dealer_country":
+			listing.Dealer.Country = v
+			if code := countryCode(v); code != "" {
+				listing.Dealer.Country = code
+			}
+		case "location":
+			listing.Location = v
+		case "description":
+			listing.Description = v
+		}
+	}
+	for _, name := range featureFields {
+		if v := values[name]; v != "" {
+			listing.Features.set(name, v)
+		}
+	}
+}
+
+// value reads the field from s; see fieldSpec.
+func (f fieldSpec) value(s *goquery.Selection) string {
+	selectors := f.Selector
+	if len(selectors) == 0 {
+		selectors = selectorList{""}
+	}
+	for _, sel := range selectors {
+		m := s
+		if sel != "" {
+			m = s.Find(sel)
+		}
+		if m.Length() == 0 {
+			continue
+		}
+		if f.Last {
+			m = m.Last()
+		} else {
+			m = m.First()
+		}
+
+		var v string
+		if f.Attr != "" {
+			var ok bool
+			if v, ok = m.Attr(f.Attr); !ok {
+				continue
+			}
+		} else {
+			v = text(m)
+		}
+		v = strings.TrimSpace(strings.Trim(strings.TrimSpace(v), f.Trim))
+		if f.Decode != "" {
+			v = decodings[string(f.Decode)](v)
+		}
+		if f.Regex != nil {
+			v = f.Regex.extract(v)
+		}
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// text returns the text of s like Selection.Text, but with a line break
+// for every <br>, so that the lines of an address stay apart.
+func text(s *goquery.Selection) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range s.Nodes {
+		walk(n)
+	}
+	return b.String()
+}
+
+func (p *pattern) extract(s string) string {
+	m := p.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return strings.TrimSpace(m[1])
+	}
+	return strings.TrimSpace(m[0])
+}
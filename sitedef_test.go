@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestFieldSpecValue(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<div>
+		<p class="a"></p>
+		<p class="b" data-x="42"> Year: 1956 </p>
+		<p class="b">Make :</p>
+	</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"text", "{selector: .b}", "Year: 1956"},
+		{"fallback past empty match", "{selector: [.a, .missing, .b]}", "Year: 1956"},
+		{"last", "{selector: .b, last: true}", "Make :"},
+		{"trim", "{selector: .b, last: true, trim: ':'}", "Make"},
+		{"attribute", "{selector: .b, attr: data-x}", "42"},
+		{"missing attribute", "{selector: .a, attr: data-x}", ""},
+		{"regex group", "{selector: .b, regex: 'Year:(.*)'}", "1956"},
+		{"regex whole match", "{selector: .b, regex: '\\d+'}", "1956"},
+		{"regex no match", "{selector: .b, regex: 'Hours'}", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := parseSiteDefinition([]byte("listing:\n  item: div\n  fields:\n    title: " + tt.yaml + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := def.Listing.Fields["title"].value(doc.Selection); got != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSiteDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		name, def, want string
+	}{
+		{"no item", `{"listing": {"fields": {"title": {"selector": "h3"}}}}`, "listing.item"},
+		{"unknown field", "listing:\n  item: div\n  fields:\n    titel: {selector: h3}\n", `unknown field "titel"`},
+		{"unknown key", "listing:\n  item: div\n  fields:\n    title: {selectr: h3}\n", "selectr"},
+		{"bad table field", "listing:\n  item: div\ndetail:\n  attributes:\n    fields: {Make: brand}\n", `unknown field "brand"`},
+		{"bad equipment field", "listing:\n  item: div\ndetail:\n  equipment:\n    fields: {Front loader: make}\n", `unknown feature "make"`},
+		{"bad regex", "listing:\n  item: div\n  fields:\n    year: {regex: '('}\n", "missing closing )"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSiteDefinition([]byte(tt.def))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadSiteDefinitions fixes a field by overriding the built-in
+// definition, as a user would after a site redesign.
+func TestLoadSiteDefinitions(t *testing.T) {
+	builtin := siteDefinitions["landwirt"]
+	t.Cleanup(func() { siteDefinitions["landwirt"] = builtin })
+
+	dir := t.TempDir()
+	def := `{"listing": {"item": ".row.gmmtreffer", "fields": {"title": {"selector": ".bildboxgmm img", "attr": "alt"}}}}`
+	if err := os.WriteFile(filepath.Join(dir, "landwirt.json"), []byte(def), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSiteDefinitions(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	listings, _ := landwirt{}.ParseListing(loadFixture(t, "testdata/landwirt/listing-1.html"))
+	if len(listings) != 2 || listings[1].Title != "McCormick TTX 190" || listings[1].URL != "" {
+		t.Errorf("override not applied: %+v", listings)
+	}
+	if siteDefinitions["agriaffaires"] == nil {
+		t.Error("agriaffaires definition dropped")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Source is a used machinery marketplace that can be crawled. The crawler
+// fetches the pages; a Source only knows where they are and how to read them.
+type Source interface {
+	// Name is the identifier used to select the source on the command line.
+	Name() string
+	// DefaultURL is the listing page crawled when no -url is given.
+	DefaultURL() string
+	// Politeness is the default crawl rate for the site; the scrape flags
+	// can override it.
+	Politeness() politeness
+	// ListingURL returns the URL of the given 1-based results page. The
+	// crawl starts from page 1 and then follows the links ParseListing
+	// finds.
+	ListingURL(baseURL string, page int) string
+	// ParseListing extracts the adverts on a results page and returns the
+	// absolute URL of the following page, or "" if this is the last.
+	ParseListing(doc *goquery.Document) ([]Listing, string)
+	// ParseDetail fills in the fields only available on the advert page.
+	ParseDetail(doc *goquery.Document, listing *Listing)
+}
+
+var sources = map[string]Source{}
+
+// registerSource makes a Source selectable by name. Each site registers
+// itself from an init function in its own file.
+func registerSource(s Source) {
+	if _, dup := sources[s.Name()]; dup {
+		panic("duplicate source " + s.Name())
+	}
+	sources[s.Name()] = s
+}
+
+func lookupSource(name string) (Source, error) {
+	s, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q (available: %s)", name, strings.Join(sourceNames(), ", "))
+	}
+	return s, nil
+}
+
+func sourceNames() []string {
+	return sortedKeys(sources)
+}
+
+// idFromURL returns the first capture of re in url, or "" if it does not
+// match.
+func idFromURL(re *regexp.Regexp, url string) string {
+	if m := re.FindStringSubmatch(url); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// absoluteURL prefixes site-relative links with the site's origin.
+func absoluteURL(origin, href string) string {
+	if href == "" || strings.HasPrefix(href, "http") {
+		return href
+	}
+	return origin + href
+}
+
+// resolveURL resolves href against the URL of the page it was found on. It
+// returns "" if href is relative and the page's URL is not known.
+func resolveURL(doc *goquery.Document, href string) string {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	if doc.Url == nil {
+		if !ref.IsAbs() {
+			return ""
+		}
+		return ref.String()
+	}
+	return doc.Url.ResolveReference(ref).String()
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// store is the persistent listing database. Each advert is one row keyed by
+// source and the site's listing ID, updated in place on every run.
+type store struct {
+	db *sql.DB
+}
+
+// storeMigrations are applied in order to bring a database up to date. The
+// number applied so far is kept in PRAGMA user_version, so existing entries
+// must never be edited: add a new one instead.
+var storeMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS listings (
+	source             TEXT NOT NULL,
+	id                 TEXT NOT NULL,
+	url                TEXT NOT NULL,
+	title              TEXT NOT NULL,
+	make               TEXT NOT NULL,
+	model              TEXT NOT NULL,
+	year               INTEGER NOT NULL,
+	hours              INTEGER NOT NULL,
+	power_hp           INTEGER NOT NULL,
+	power_kw           INTEGER NOT NULL,
+	condition          TEXT NOT NULL,
+	price_amount       REAL NOT NULL,
+	price_currency     TEXT NOT NULL,
+	vat_included       INTEGER NOT NULL,
+	vat_rate           REAL NOT NULL,
+	original_amount    REAL NOT NULL,
+	price_text         TEXT NOT NULL,
+	price_reporting    REAL NOT NULL,
+	reporting_currency TEXT NOT NULL,
+	dealer             TEXT NOT NULL,
+	location           TEXT NOT NULL,
+	phone              TEXT NOT NULL,
+	image_url          TEXT NOT NULL,
+	description        TEXT NOT NULL,
+	attributes         TEXT NOT NULL,
+	equipment          TEXT NOT NULL,
+	first_seen         TIMESTAMP NOT NULL,
+	last_seen          TIMESTAMP NOT NULL,
+	PRIMARY KEY (source, id)
+);`,
+	`ALTER TABLE listings ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE listings ADD COLUMN search_url TEXT NOT NULL DEFAULT '';
+CREATE TABLE listing_history (
+	source          TEXT NOT NULL,
+	id              TEXT NOT NULL,
+	observed_at     TIMESTAMP NOT NULL,
+	status          TEXT NOT NULL,
+	price_amount    REAL NOT NULL,
+	price_currency  TEXT NOT NULL,
+	vat_included    INTEGER NOT NULL,
+	vat_rate        REAL NOT NULL,
+	original_amount REAL NOT NULL,
+	hours           INTEGER NOT NULL,
+	FOREIGN KEY (source, id) REFERENCES listings (source, id)
+);
+CREATE INDEX listing_history_listing ON listing_history (source, id, observed_at);
+CREATE INDEX listing_history_observed ON listing_history (observed_at);`,
+	// The phone column held agriaffaires' still-encoded numbers; the next
+	// crawl with -details fills contacts in their place.
+	`ALTER TABLE listings ADD COLUMN contacts TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE listings DROP COLUMN phone;`,
+	// Dealers become records of their own; the dealer column keeps the name
+	// so that older listings still show one. Contacts move to the dealers
+	// of the listings that have them, keyed by the lowercased name as the
+	// listings have no dealer postcode; the next crawl files dealers under
+	// their dealerKey.
+	`CREATE TABLE dealers (
+	source     TEXT NOT NULL,
+	id         TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	address    TEXT NOT NULL,
+	postcode   TEXT NOT NULL,
+	country    TEXT NOT NULL,
+	contacts   TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	first_seen TIMESTAMP NOT NULL,
+	last_seen  TIMESTAMP NOT NULL,
+	PRIMARY KEY (source, id)
+);
+ALTER TABLE listings ADD COLUMN dealer_id TEXT NOT NULL DEFAULT '';
+UPDATE listings SET dealer_id = replace(lower(trim(dealer)), ' ', '-')
+WHERE contacts != '[]' AND trim(dealer) != '';
+INSERT INTO dealers
+SELECT source, dealer_id, dealer, '', '', '', contacts, '', first_seen, last_seen
+FROM listings WHERE dealer_id != '' ORDER BY last_seen
+ON CONFLICT (source, id) DO UPDATE SET
+	contacts = excluded.contacts,
+	first_seen = min(dealers.first_seen, excluded.first_seen),
+	last_seen = excluded.last_seen;
+ALTER TABLE listings DROP COLUMN contacts;
+CREATE INDEX listings_dealer ON listings (source, dealer_id);`,
+	`ALTER TABLE listings ADD COLUMN postcode TEXT NOT NULL DEFAULT '';
+ALTER TABLE listings ADD COLUMN city TEXT NOT NULL DEFAULT '';
+ALTER TABLE listings ADD COLUMN region TEXT NOT NULL DEFAULT '';
+ALTER TABLE listings ADD COLUMN country TEXT NOT NULL DEFAULT '';
+CREATE INDEX listings_country ON listings (country);`,
+	// Every listing is its own canonical machine until matchMachines runs.
+	`ALTER TABLE listings ADD COLUMN image_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE listings ADD COLUMN machine_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE listings ADD COLUMN canonical INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX listings_machine ON listings (machine_id);`,
+	`ALTER TABLE listings ADD COLUMN series TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE listings ADD COLUMN features TEXT NOT NULL DEFAULT '{}';`,
+	// Every listing is matched again once, by the next scrape.
+	`ALTER TABLE listings ADD COLUMN matched INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX listings_unmatched ON listings (source, id) WHERE matched = 0;
+CREATE INDEX listings_make ON listings (make);`,
+}
+
+// listingColumns is the column order used for both writes and reads.
+var listingColumns = []string{
+	"source", "id", "url", "title", "make", "model", "year", "hours",
+	"power_hp", "power_kw", "condition",
+	"price_amount", "price_currency", "vat_included", "vat_rate", "original_amount", "price_text",
+	"price_reporting", "reporting_currency",
+	"dealer", "location", "image_url", "description", "attributes", "equipment",
+	"first_seen", "last_seen", "status", "search_url", "dealer_id",
+	"postcode", "city", "region", "country",
+	"image_hash", "machine_id", "canonical", "series", "features",
+}
+
+// detailColumns only come from advert pages. A run without -details must not
+// blank what an earlier run collected, so empty values leave them alone.
+var detailColumns = map[string]bool{
+	"make": true, "series": true, "model": true, "condition": true, "dealer_id": true, "image_hash": true,
+	"description": true, "attributes": true, "equipment": true, "features": true,
+}
+
+// conversionColumns are only filled by runs with -currency. A run without
+// it keeps the stored conversion as long as the price has not changed.
+var conversionColumns = map[string]bool{"price_reporting": true, "reporting_currency": true}
+
+// machineColumns belong to matchMachines: new listings start as machines
+// of their own and upserts leave the columns alone.
+var machineColumns = map[string]bool{"machine_id": true, "canonical": true}
+
+// matchColumns are the columns sameMachine compares. An upsert that
+// changes any of them clears the listing's matched flag, so that the next
+// matchMachines compares it again.
+var matchColumns = []string{
+	"make", "model", "year", "hours", "dealer_id", "price_amount", "price_currency", "vat_included", "vat_rate",
+	"price_reporting", "reporting_currency", "image_hash", "status",
+}
+
+func openStore(path string) (*store, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_time_format=sqlite")
+	if err != nil {
+		return nil, fmt.Errorf("error opening store %s: %w", path, err)
+	}
+	if err := migrateStore(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error updating store schema in %s: %w", path, err)
+	}
+	return &store{db: db}, nil
+}
+
+func migrateStore(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(storeMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(storeMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// listingKey returns the ID a listing is stored under. Adverts whose ID
+// could not be read from the URL fall back to the URL itself.
+func listingKey(l Listing) string {
+	if l.ID != "" {
+		return l.ID
+	}
+	return l.URL
+}
+
+// upsertListings inserts new listings and refreshes existing ones, and
+// records each observation in listing_history. Each listing's dealer is
+// stored under its dealerKey, keeping what is already known of it where
+// the listing leaves a detail empty. The first-seen time of an
+// existing listing is kept; last-seen is set to the listing's ScrapedAt and
+// the listing is marked active again if it had been removed. A listing
+// scraped before it was last seen, from cached pages, changes nothing.
+func (s *store) upsertListings(listings []Listing) error {
+	var updates []string
+	for _, c := range listingColumns {
+		switch {
+		case c == "source" || c == "id" || c == "first_seen" || machineColumns[c]:
+		case detailColumns[c]:
+			updates = append(updates, fmt.Sprintf("%[1]s = CASE WHEN excluded.%[1]s IN ('', '{}', '[]', 'null') THEN listings.%[1]s ELSE excluded.%[1]s END", c))
+		case conversionColumns[c]:
+			updates = append(updates, fmt.Sprintf("%[1]s = CASE WHEN excluded.reporting_currency = '' AND excluded.price_amount = listings.price_amount "+
+				"AND excluded.price_currency = listings.price_currency THEN listings.%[1]s ELSE excluded.%[1]s END", c))
+		default:
+			updates = append(updates, fmt.Sprintf("%[1]s = excluded.%[1]s", c))
+		}
+	}
+	var unchanged []string
+	for _, c := range matchColumns {
+		switch {
+		case detailColumns[c]:
+			unchanged = append(unchanged, fmt.Sprintf("(excluded.%[1]s IN ('', '{}', '[]', 'null') OR excluded.%[1]s = listings.%[1]s)", c))
+		case conversionColumns[c]:
+			unchanged = append(unchanged, fmt.Sprintf("(excluded.reporting_currency = '' OR excluded.%[1]s = listings.%[1]s)", c))
+		default:
+			unchanged = append(unchanged, fmt.Sprintf("excluded.%[1]s = listings.%[1]s", c))
+		}
+	}
+	updates = append(updates, "matched = CASE WHEN "+strings.Join(unchanged, " AND ")+" THEN listings.matched ELSE 0 END")
+	query := fmt.Sprintf("INSERT INTO listings (%s) VALUES (%s) ON CONFLICT (source, id) DO UPDATE SET %s "+
+		"WHERE excluded.last_seen >= listings.last_seen",
+		strings.Join(listingColumns, ", "),
+		placeholders(len(listingColumns)),
+		strings.Join(updates, ", "))
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("error preparing upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	history, err := tx.Prepare(insertHistory)
+	if err != nil {
+		return fmt.Errorf("error preparing history insert: %w", err)
+	}
+	defer history.Close()
+
+	dealer, err := tx.Prepare(upsertDealer)
+	if err != nil {
+		return fmt.Errorf("error preparing dealer upsert: %w", err)
+	}
+	defer dealer.Close()
+
+	for _, l := range listings {
+		attributes, err := json.Marshal(l.Attributes)
+		if err != nil {
+			return err
+		}
+		equipment, err := json.Marshal(l.Equipment)
+		if err != nil {
+			return err
+		}
+		features := []byte("{}")
+		if !l.Features.isZero() {
+			if features, err = json.Marshal(l.Features); err != nil {
+				return err
+			}
+		}
+		seen := l.ScrapedAt.UTC()
+		dealerID := dealerKey(l.Dealer)
+		if dealerID != "" {
+			d := l.Dealer
+			contacts, err := json.Marshal(d.Contacts)
+			if err != nil {
+				return err
+			}
+			_, err = dealer.Exec(l.Source, dealerID, d.Name, d.Address, d.Postcode, d.Country,
+				string(contacts), d.URL, seen, seen)
+			if err != nil {
+				return fmt.Errorf("error storing %s dealer %s: %w", l.Source, dealerID, err)
+			}
+		}
+		_, err = stmt.Exec(
+			l.Source, listingKey(l), l.URL, l.Title, l.Make, l.Model, l.Year, l.Hours,
+			l.PowerHP, l.PowerKW, l.Condition,
+			l.Price.Amount, l.Price.Currency, l.Price.VATIncluded, l.Price.VATRate, l.Price.OriginalAmount, l.PriceText,
+			l.PriceInReportingCurrency, l.ReportingCurrency,
+			l.Dealer.Name, l.Location, l.ImageURL, l.Description, string(attributes), string(equipment),
+			seen, seen, statusActive, l.SearchURL, dealerID,
+			l.Place.Postcode, l.Place.City, l.Place.Region, l.Place.Country,
+			l.ImageHash, 0, true, l.Series, string(features),
+		)
+		if err != nil {
+			return fmt.Errorf("error storing %s listing %s: %w", l.Source, listingKey(l), err)
+		}
+		_, err = history.Exec(l.Source, listingKey(l), seen, statusActive,
+			l.Price.Amount, l.Price.Currency, l.Price.VATIncluded, l.Price.VATRate, l.Price.OriginalAmount, l.Hours)
+		if err != nil {
+			return fmt.Errorf("error recording history of %s listing %s: %w", l.Source, listingKey(l), err)
+		}
+	}
+	return tx.Commit()
+}
+
+// listingFilter narrows which stored listings are read back. Zero values
+// match everything.
+type listingFilter struct {
+	Source  string
+	ID      string
+	Country string // ISO code
+}
+
+// queryListings returns stored listings ordered by source and ID, with
+// their dealers.
+func (s *store) queryListings(f listingFilter) ([]Listing, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, f.Source)
+	}
+	if f.ID != "" {
+		where = append(where, "id = ?")
+		args = append(args, f.ID)
+	}
+	if f.Country != "" {
+		where = append(where, "country = ?")
+		args = append(args, f.Country)
+	}
+	return s.listingsWhere(strings.Join(where, " AND "), args, f.Source)
+}
+
+// listingsWhere returns the stored listings that match the SQL condition
+// where, or all of them if it is "", ordered by source and ID. Dealers are
+// read from source, or from every source if it is "".
+func (s *store) listingsWhere(where string, args []any, source string) ([]Listing, error) {
+	query := "SELECT " + strings.Join(listingColumns, ", ") + " FROM listings"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY source, id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading listings: %w", err)
+	}
+	defer rows.Close()
+
+	var listings []Listing
+	for rows.Next() {
+		l, err := scanListing(rows)
+		if err != nil {
+			return nil, err
+		}
+		listings = append(listings, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	dealers, err := s.queryDealers(source)
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range listings {
+		if d, ok := dealers[[2]string{l.Source, l.Dealer.ID}]; ok {
+			listings[i].Dealer = d
+		}
+	}
+	return listings, nil
+}
+
+func scanListing(rows *sql.Rows) (Listing, error) {
+	var (
+		l                     Listing
+		attributes, equipment string
+		features              string
+		firstSeen, lastSeen   time.Time
+	)
+	err := rows.Scan(
+		&l.Source, &l.ID, &l.URL, &l.Title, &l.Make, &l.Model, &l.Year, &l.Hours,
+		&l.PowerHP, &l.PowerKW, &l.Condition,
+		&l.Price.Amount, &l.Price.Currency, &l.Price.VATIncluded, &l.Price.VATRate, &l.Price.OriginalAmount, &l.PriceText,
+		&l.PriceInReportingCurrency, &l.ReportingCurrency,
+		&l.Dealer.Name, &l.Location, &l.ImageURL, &l.Description, &attributes, &equipment,
+		&firstSeen, &lastSeen, &l.Status, &l.SearchURL, &l.Dealer.ID,
+		&l.Place.Postcode, &l.Place.City, &l.Place.Region, &l.Place.Country,
+		&l.ImageHash, &l.MachineID, &l.Canonical, &l.Series, &features,
+	)
+	if err != nil {
+		return Listing{}, fmt.Errorf("error reading listing: %w", err)
+	}
+	if err := json.Unmarshal([]byte(attributes), &l.Attributes); err != nil {
+		return Listing{}, fmt.Errorf("listing %s/%s: bad attributes: %w", l.Source, l.ID, err)
+	}
+	if err := json.Unmarshal([]byte(equipment), &l.Equipment); err != nil {
+		return Listing{}, fmt.Errorf("listing %s/%s: bad equipment: %w", l.Source, l.ID, err)
+	}
+	if err := json.Unmarshal([]byte(features), &l.Features); err != nil {
+		return Listing{}, fmt.Errorf("listing %s/%s: bad features: %w", l.Source, l.ID, err)
+	}
+	l.FirstSeen = firstSeen
+	l.ScrapedAt = lastSeen
+	return l, nil
+}
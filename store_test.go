@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStore opens a new store in a temporary directory.
+func testStore(t *testing.T) *store {
+	t.Helper()
+	st, err := openStore(filepath.Join(t.TempDir(), "tractors.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// storedListing reads back the listing stored under source and id.
+func storedListing(t *testing.T, st *store, source, id string) Listing {
+	t.Helper()
+	listings, err := st.queryListings(listingFilter{Source: source, ID: id})
+	if err != nil || len(listings) != 1 {
+		t.Fatalf("query %s/%s: %v, %d listings", source, id, err, len(listings))
+	}
+	return listings[0]
+}
+
+// TestUpsertKeepsConversion stores a converted price and upserts the
+// listing again without -currency.
+func TestUpsertKeepsConversion(t *testing.T) {
+	st := testStore(t)
+	now := time.Now()
+	l := Listing{Source: "landwirt", ID: "4483344", Price: Price{Amount: 32500, Currency: "EUR"}, ScrapedAt: now,
+		PriceInReportingCurrency: 27397.5, ReportingCurrency: "GBP"}
+	if err := st.upsertListings([]Listing{l}); err != nil {
+		t.Fatal(err)
+	}
+
+	l.PriceInReportingCurrency, l.ReportingCurrency = 0, ""
+	if err := st.upsertListings([]Listing{l}); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedListing(t, st, "landwirt", "4483344"); got.PriceInReportingCurrency != 27397.5 || got.ReportingCurrency != "GBP" {
+		t.Errorf("unchanged price: conversion became %v %q", got.PriceInReportingCurrency, got.ReportingCurrency)
+	}
+
+	// A conversion of the old price would be wrong for the new one.
+	l.Price.Amount = 30000
+	if err := st.upsertListings([]Listing{l}); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedListing(t, st, "landwirt", "4483344"); got.PriceInReportingCurrency != 0 || got.ReportingCurrency != "" {
+		t.Errorf("new price: stale conversion %v %q kept", got.PriceInReportingCurrency, got.ReportingCurrency)
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tractors.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A store made by the first version, with one listing in it.
+	if _, err := db.Exec(storeMigrations[0] + "PRAGMA user_version = 1;"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO listings VALUES ('landwirt', '4491022', 'https://www.landwirt.com/4491022', 'Fordson Major',
+		'Fordson', 'Major', 1956, 0, 40, 30, '', 0, '', 0, 0, 0, 'Price on request', 0, '', 'Lagerhaus', '4600 Wels', '0043 7242',
+		'', '', '{}', '[]', '2024-09-18 10:00:00', '2024-09-18 10:00:00')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		st, err := openStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var version int
+		if err := st.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != len(storeMigrations) {
+			t.Errorf("user_version = %d, %v; want %d", version, err, len(storeMigrations))
+		}
+		l := storedListing(t, st, "landwirt", "4491022")
+		if l.Title != "Fordson Major" || l.Status != statusActive || !l.Canonical || !l.Features.isZero() || l.Dealer.Name != "Lagerhaus" {
+			t.Errorf("migrated listing %+v", l)
+		}
+		st.Close()
+	}
+}
+
+// TestMigrateContacts migrates a store whose listings had contacts of their
+// own, from before dealers were records.
+func TestMigrateContacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tractors.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(strings.Join(storeMigrations[:3], "\n") + "PRAGMA user_version = 3;"); err != nil {
+		t.Fatal(err)
+	}
+	contacts := `[{"type":"phone","number":"+441452862232","raw":"(+44) 1452862232"}]`
+	for _, id := range []string{"45219407", "45219408"} {
+		_, err = db.Exec(`INSERT INTO listings (source, id, url, title, make, model, year, hours, power_hp, power_kw,
+			condition, price_amount, price_currency, vat_included, vat_rate, original_amount, price_text, price_reporting,
+			reporting_currency, dealer, location, image_url, description, attributes, equipment, first_seen, last_seen, contacts)
+			VALUES ('agriaffaires', ?, '', '', '', '', 0, 0, 0, 0, '', 0, '', 0, 0, 0, '', 0, '', 'Cotswold Tractors', '',
+			'', '', '{}', '[]', '2024-09-18 10:00:00', '2024-09-18 10:00:00', ?)`, id, contacts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	want := []Contact{{Type: contactPhone, Number: "+441452862232", Raw: "(+44) 1452862232"}}
+	for _, id := range []string{"45219407", "45219408"} {
+		l := storedListing(t, st, "agriaffaires", id)
+		if l.Dealer.Name != "Cotswold Tractors" || !reflect.DeepEqual(l.Dealer.Contacts, want) {
+			t.Errorf("migrated listing %s has dealer %+v", id, l.Dealer)
+		}
+	}
+}
+
+func TestUpsertRoundTrip(t *testing.T) {
+	st := testStore(t)
+	first := time.Date(2024, 9, 18, 10, 0, 0, 0, time.UTC)
+	l := Listing{
+		Source: "agriaffaires", ID: "44582981", URL: "https://www.agriaffaires.co.uk/used/farm-tractor/44582981/claas-arion-640.html",
+		SearchURL: "https://www.agriaffaires.co.uk/used/farm-tractor/1/claas.html", ScrapedAt: first,
+		Title: "Claas Arion 640", Make: "Claas", Series: "Arion", Model: "Arion 640", Year: 2016, Hours: 5200, PowerHP: 185, PowerKW: 136,
+		Condition: "Used",
+		Price:     Price{Amount: 58000, Currency: "GBP", VATIncluded: true, VATRate: 20, OriginalAmount: 62000}, PriceText: "£58,000 incl. VAT",
+		PriceInReportingCurrency: 68802.85, ReportingCurrency: "EUR",
+		Dealer: Dealer{Name: "Ripon Farm Services", Postcode: "HG4 1TU", Country: "GB", URL: "https://www.agriaffaires.co.uk/pro/ripon",
+			Contacts: []Contact{{Type: contactPhone, Number: "+441765692020", Raw: "(+44) 1765 692020"}}},
+		Location: "Ripon", Place: Place{Postcode: "HG4", City: "Ripon", Region: "North Yorkshire", Country: "GB"},
+		ImageURL: "https://photos.agriaffaires.co.uk/44582981.jpg", ImageHash: "0f0f0f0f0f0f0f0e", Description: "One owner.",
+		Features:   Features{Transmission: "cvt", PTOSpeeds: []int{540, 1000}, FrontTyres: "480/65 R24"},
+		Attributes: map[string]string{"Front Tire Dimension": "480/65x24"},
+		Equipment:  []string{"Front linkage"},
+	}
+	if err := st.upsertListings([]Listing{l}); err != nil {
+		t.Fatal(err)
+	}
+	want := l
+	want.FirstSeen, want.Status, want.Canonical = first, statusActive, true
+	want.Dealer.ID = dealerKey(l.Dealer)
+	got := storedListing(t, st, l.Source, l.ID)
+	if !got.FirstSeen.Equal(first) || !got.ScrapedAt.Equal(first) {
+		t.Errorf("seen %v to %v, want %v", got.FirstSeen, got.ScrapedAt, first)
+	}
+	got.FirstSeen, got.ScrapedAt = first, first
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %+v\nwant %+v", got, want)
+	}
+
+	// A later run from the results pages only: details are kept, the
+	// rest is updated and the listing keeps its first-seen time.
+	later := first.Add(24 * time.Hour)
+	update := Listing{Source: l.Source, ID: l.ID, URL: l.URL, Title: l.Title, Year: l.Year, Hours: 5230, Price: l.Price, ScrapedAt: later}
+	if err := st.upsertListings([]Listing{update}); err != nil {
+		t.Fatal(err)
+	}
+	got = storedListing(t, st, l.Source, l.ID)
+	if got.Hours != 5230 || !got.ScrapedAt.Equal(later) || !got.FirstSeen.Equal(first) {
+		t.Errorf("after update: hours %d, seen %v to %v", got.Hours, got.FirstSeen, got.ScrapedAt)
+	}
+	if got.Make != "Claas" || got.Description != "One owner." || len(got.Attributes) != 1 || len(got.Equipment) != 1 || got.Features.Transmission != "cvt" {
+		t.Errorf("details lost: %+v", got)
+	}
+	var observations int
+	if err := st.db.QueryRow("SELECT COUNT(*) FROM listing_history WHERE id = ?", l.ID).Scan(&observations); err != nil || observations != 2 {
+		t.Errorf("history rows = %d, %v; want 2", observations, err)
+	}
+}
+
+// TestUpsertStale upserts a listing scraped from pages cached before it was
+// last seen, as an offline run does, and then the same pages again.
+func TestUpsertStale(t *testing.T) {
+	st := testStore(t)
+	now := time.Now()
+	cached := now.Add(-48 * time.Hour)
+	l := Listing{Source: "landwirt", ID: "4483344", Price: Price{Amount: 30000, Currency: "EUR"}, ScrapedAt: now}
+	if err := st.upsertListings([]Listing{l}); err != nil {
+		t.Fatal(err)
+	}
+	stale := l
+	stale.Price.Amount, stale.ScrapedAt = 32500, cached
+	for i := 0; i < 2; i++ {
+		if err := st.upsertListings([]Listing{stale}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := storedListing(t, st, "landwirt", "4483344")
+	if !got.ScrapedAt.Equal(now) || got.Price.Amount != 30000 {
+		t.Errorf("after a stale upsert: last seen %v, price %v; want %v, 30000", got.ScrapedAt, got.Price.Amount, now)
+	}
+	history, err := st.listingHistory("landwirt", "4483344")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 {
+		t.Errorf("stale upserts recorded %d observations, want 1", len(history))
+	}
+
+	// Cached pages parsed twice are one observation.
+	other := Listing{Source: "landwirt", ID: "4500001", ScrapedAt: cached}
+	for i := 0; i < 2; i++ {
+		if err := st.upsertListings([]Listing{other}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if history, err = st.listingHistory("landwirt", "4500001"); err != nil || len(history) != 1 {
+		t.Errorf("history of a listing upserted twice at the same time: %d observations, %v; want 1", len(history), err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// numberColumns are the csvColumns written to spreadsheets as numbers
+// rather than text.
+var numberColumns = map[string]bool{
+	"Machine": true, "Year": true, "Hours": true, "Power (hp)": true, "Power (kW)": true, "Max Speed (km/h)": true,
+	"Price": true, "VAT Rate": true, "Original Price": true, "Price In Reporting Currency": true, "Distance (km)": true,
+}
+
+// exportXlsx writes listings to an Excel workbook with a sheet for each
+// source, in the columns of the CSV export.
+func exportXlsx(filename string, listings []Listing, opts exportOptions) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	bySource := make(map[string][]Listing)
+	for _, l := range listings {
+		bySource[l.Source] = append(bySource[l.Source], l)
+	}
+	for _, source := range sortedKeys(bySource) {
+		if err := writeSheet(f, source, bySource[source], opts); err != nil {
+			return fmt.Errorf("error writing %s sheet: %w", source, err)
+		}
+	}
+	// A new workbook starts with an empty sheet, which is kept only if
+	// there is nothing to export.
+	if len(bySource) > 0 {
+		if err := f.DeleteSheet("Sheet1"); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating results directory: %w", err)
+	}
+	if err := f.SaveAs(filename); err != nil {
+		return fmt.Errorf("error writing workbook: %w", err)
+	}
+	return nil
+}
+
+// writeSheet adds a sheet named after source with a header row, kept in
+// view while scrolling, and a row for each listing.
+func writeSheet(f *excelize.File, source string, listings []Listing, opts exportOptions) error {
+	if _, err := f.NewSheet(source); err != nil {
+		return err
+	}
+	sw, err := f.NewStreamWriter(source)
+	if err != nil {
+		return err
+	}
+	if err := sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+
+	columns := exportColumns(listings, opts.AttributeColumns)
+	header := make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+	for i := range listings {
+		row := make([]any, len(columns))
+		for j, c := range columns {
+			v := c.value(&listings[i])
+			if n, err := strconv.ParseFloat(v, 64); err == nil && numberColumns[c.Name] {
+				row[j] = n
+			} else if v != "" {
+				row[j] = v
+			}
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow(cell, row); err != nil {
+			return err
+		}
+	}
+	return sw.Flush()
+}